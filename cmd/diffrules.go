@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/izzatbey/soc-norm-events/internal/normalizer"
+	"github.com/spf13/cobra"
+)
+
+var diffRulesOpts struct {
+	old      string
+	new      string
+	examples int
+	json     bool
+}
+
+var diffRulesCmd = &cobra.Command{
+	Use:   "diff-rules --new <rules.yaml> [--old <rules.yaml>] [file]",
+	Short: "Compare what two rulesets do to a sample of events",
+	Long: `Normalize every event of a sample file, or of stdin when no file (or "-") is
+given, with two rulesets and report the differences: which fields changed,
+how many events of each source category were affected and example events
+for every change.
+
+--old defaults to the built-in ruleset, so
+
+  normalizer diff-rules --new rules.yaml data/data.json
+
+shows the blast radius of deploying rules.yaml. Input is read like the
+normalize command reads it.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if diffRulesOpts.new == "" {
+			log.Fatalf("❌ --new is required")
+		}
+		if err := normalizer.SetFailurePolicies(cfg.RuleFailurePolicy); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		if err := normalizer.SetCollisionPolicies(cfg.CollisionPolicy); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		oldRules, err := normalizer.LoadRuleset(diffRulesOpts.old)
+		if err != nil {
+			log.Fatalf("❌ load old ruleset: %v", err)
+		}
+		newRules, err := normalizer.LoadRuleset(diffRulesOpts.new)
+		if err != nil {
+			log.Fatalf("❌ load new ruleset: %v", err)
+		}
+
+		in, closeIn, err := openInput(args)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		defer closeIn()
+
+		report := newRulesetDiff(oldRules, newRules)
+		err = normalizer.ReadEvents(in, func(raw []byte) error {
+			report.add(string(raw))
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("❌ event %d: %v", report.Events+1, err)
+		}
+
+		w := bufio.NewWriter(os.Stdout)
+		defer w.Flush()
+		if diffRulesOpts.json {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			return
+		}
+		report.write(w)
+	},
+}
+
+// rulesetDiff accumulates the differences between two rulesets over a
+// sample of events.
+type rulesetDiff struct {
+	Old        string                   `json:"old"`
+	New        string                   `json:"new"`
+	Events     int                      `json:"events"`
+	Changed    int                      `json:"changed"`
+	Categories map[string]*categoryDiff `json:"categories"`
+	Changes    []*fieldDiff             `json:"changes"`
+
+	oldRules, newRules *normalizer.Ruleset
+	byKey              map[string]*fieldDiff
+}
+
+// categoryDiff counts the events of one source category, as detected by the
+// old ruleset, and how many of them the new ruleset normalizes differently.
+type categoryDiff struct {
+	Events  int `json:"events"`
+	Changed int `json:"changed"`
+}
+
+// fieldDiff is one kind of change (a field added, renamed, overwritten or
+// deleted, a different category or a different failure) with the number of
+// events it affects and a few examples.
+type fieldDiff struct {
+	Kind     string        `json:"kind"`
+	Field    string        `json:"field"`
+	From     string        `json:"from,omitempty"`
+	Events   int           `json:"events"`
+	Examples []diffExample `json:"examples"`
+}
+
+// diffExample is one event showing a change.
+type diffExample struct {
+	Event    int             `json:"event"`
+	ID       string          `json:"id,omitempty"`
+	Category string          `json:"category"`
+	Old      json.RawMessage `json:"old,omitempty"`
+	New      json.RawMessage `json:"new,omitempty"`
+}
+
+// Kinds of change that are not about a single field.
+const (
+	changeCategory = "category"
+	changeFailure  = "failure"
+)
+
+func newRulesetDiff(oldRules, newRules *normalizer.Ruleset) *rulesetDiff {
+	return &rulesetDiff{
+		Old:        oldRules.String(),
+		New:        newRules.String(),
+		Categories: map[string]*categoryDiff{},
+		Changes:    []*fieldDiff{},
+		oldRules:   oldRules,
+		newRules:   newRules,
+		byKey:      map[string]*fieldDiff{},
+	}
+}
+
+// add normalizes raw with both rulesets and records how the results differ.
+func (d *rulesetDiff) add(raw string) {
+	d.Events++
+	oldCategory := d.oldRules.SourceCategory(raw)
+	category := oldCategory
+	if category == "" {
+		category = "(none)"
+	}
+	cat := d.Categories[category]
+	if cat == nil {
+		cat = &categoryDiff{}
+		d.Categories[category] = cat
+	}
+	cat.Events++
+
+	var id struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal([]byte(raw), &id)
+	example := func(old, new string) diffExample {
+		return diffExample{Event: d.Events, ID: id.ID, Category: category, Old: quote(old), New: quote(new)}
+	}
+
+	var changes []*fieldDiff
+	var examples []diffExample
+	record := func(c *fieldDiff, ex diffExample) {
+		changes = append(changes, c)
+		examples = append(examples, ex)
+	}
+
+	if newCategory := d.newRules.SourceCategory(raw); newCategory != oldCategory {
+		record(&fieldDiff{Kind: changeCategory}, example(oldCategory, newCategory))
+	}
+
+	oldOut, oldErr := d.oldRules.Normalize(raw)
+	newOut, newErr := d.newRules.Normalize(raw)
+	switch {
+	case oldErr != nil || newErr != nil:
+		if errString(oldErr) != errString(newErr) {
+			record(&fieldDiff{Kind: changeFailure}, example(errString(oldErr), errString(newErr)))
+		}
+	default:
+		fields, err := normalizer.DiffFields(oldOut, newOut)
+		if err != nil {
+			log.Printf("⚠️ Event %d not compared: %v", d.Events, err)
+			return
+		}
+		for _, f := range fields {
+			// The ruleset version and hash differ between the two rulesets.
+			if strings.HasPrefix(f.Field, "normalizer.ruleset.") {
+				continue
+			}
+			record(&fieldDiff{Kind: f.Kind, Field: f.Field, From: f.From},
+				diffExample{Event: d.Events, ID: id.ID, Category: category, Old: f.Old, New: f.New})
+		}
+	}
+
+	if len(changes) == 0 {
+		return
+	}
+	d.Changed++
+	cat.Changed++
+	for i, c := range changes {
+		key := c.Kind + "\x00" + c.From + "\x00" + c.Field
+		agg := d.byKey[key]
+		if agg == nil {
+			agg = c
+			agg.Examples = []diffExample{}
+			d.byKey[key] = agg
+			d.Changes = append(d.Changes, agg)
+		}
+		agg.Events++
+		if len(agg.Examples) < diffRulesOpts.examples {
+			agg.Examples = append(agg.Examples, examples[i])
+		}
+	}
+}
+
+// write prints the report with the most frequent changes first.
+func (d *rulesetDiff) write(w io.Writer) {
+	fmt.Fprintf(w, "old: %s\nnew: %s\n\n", d.Old, d.New)
+	fmt.Fprintf(w, "%d of %d events changed\n", d.Changed, d.Events)
+
+	categories := make([]string, 0, len(d.Categories))
+	for name := range d.Categories {
+		categories = append(categories, name)
+	}
+	sort.Strings(categories)
+	for _, name := range categories {
+		c := d.Categories[name]
+		fmt.Fprintf(w, "  %-16s %d of %d\n", name, c.Changed, c.Events)
+	}
+
+	changes := append([]*fieldDiff(nil), d.Changes...)
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Events > changes[j].Events })
+	for _, c := range changes {
+		fmt.Fprintf(w, "\n%s %s (%d events)\n", c.Kind, c.describe(), c.Events)
+		for _, ex := range c.Examples {
+			fmt.Fprintf(w, "    event %d", ex.Event)
+			if ex.ID != "" {
+				fmt.Fprintf(w, " id=%s", ex.ID)
+			}
+			fmt.Fprintf(w, " [%s]: %s -> %s\n", ex.Category, orNone(ex.Old), orNone(ex.New))
+		}
+	}
+}
+
+func (c *fieldDiff) describe() string {
+	switch {
+	case c.Field == "":
+		return ""
+	case c.From != "":
+		return c.From + " -> " + c.Field
+	default:
+		return c.Field
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// quote encodes s as a JSON string, or returns nil for "".
+func quote(s string) json.RawMessage {
+	if s == "" {
+		return nil
+	}
+	b, _ := json.Marshal(s)
+	return b
+}
+
+func orNone(v json.RawMessage) string {
+	if v == nil {
+		return "(none)"
+	}
+	return string(v)
+}
+
+func init() {
+	diffRulesCmd.Flags().StringVar(&diffRulesOpts.old, "old", "", "ruleset file to compare from (default the built-in ruleset)")
+	diffRulesCmd.Flags().StringVar(&diffRulesOpts.new, "new", "", "ruleset file to compare to")
+	diffRulesCmd.Flags().IntVar(&diffRulesOpts.examples, "examples", 3, "example events to show for each change")
+	diffRulesCmd.Flags().BoolVar(&diffRulesOpts.json, "json", false, "write the report as JSON")
+	rootCmd.AddCommand(diffRulesCmd)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/izzatbey/soc-norm-events/internal/normalizer"
+	"github.com/spf13/cobra"
+)
+
+var explainOpts struct {
+	rules    string
+	category string
+	json     bool
+}
+
+var explainCmd = &cobra.Command{
+	Use:   "explain [file]",
+	Short: "Show which rule changed which field of an event",
+	Long: `Normalize the event read from file, or from stdin when no file (or "-") is
+given, and report the detected source category, every rule executed in order
+and the fields each rule added, renamed, overwrote or deleted.
+
+Input is read like the normalize command reads it; every event of the input
+gets its own report. Use --json for a machine-readable report.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if explainOpts.rules != "" {
+			cfg.RulesFile = explainOpts.rules
+		}
+		if err := normalizer.ConfigureRules(cfg); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		if c := explainOpts.category; c != "" {
+			if categories := normalizer.ActiveRuleset().Categories(); !slices.Contains(categories, c) {
+				log.Fatalf("❌ unknown category %q, expected one of: %s", c, strings.Join(categories, ", "))
+			}
+		}
+
+		in, closeIn, err := openInput(args)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		defer closeIn()
+
+		w := bufio.NewWriter(os.Stdout)
+		defer w.Flush()
+
+		var count int
+		err = normalizer.ReadEvents(in, func(raw []byte) error {
+			count++
+			trace, err := normalizer.Explain(string(raw), explainOpts.category)
+			if err != nil {
+				log.Printf("⚠️ Event %d not explained: %v", count, err)
+				return nil
+			}
+			if explainOpts.json {
+				enc := json.NewEncoder(w)
+				enc.SetIndent("", "  ")
+				return enc.Encode(trace)
+			}
+			if count > 1 {
+				fmt.Fprintln(w)
+			}
+			writeTrace(w, trace)
+			return nil
+		})
+		if err != nil {
+			w.Flush()
+			log.Fatalf("❌ event %d: %v", count+1, err)
+		}
+	},
+}
+
+// changeMarks prefixes each kind of field change in the text report.
+var changeMarks = map[string]string{
+	normalizer.FieldAdded:       "+",
+	normalizer.FieldRenamed:     ">",
+	normalizer.FieldOverwritten: "~",
+	normalizer.FieldDeleted:     "-",
+}
+
+// writeTrace writes trace as a human-readable report.
+func writeTrace(w io.Writer, trace *normalizer.Trace) {
+	fmt.Fprintf(w, "category: %s\n", trace.Category)
+	fmt.Fprintf(w, "ruleset:  %s\n", trace.Ruleset)
+	for i, r := range trace.Rules {
+		fmt.Fprintf(w, "\n%2d. %s [%s]", i+1, r.Rule, r.Phase)
+		switch {
+		case r.Error != "":
+			fmt.Fprintf(w, " FAILED: %s\n", r.Error)
+		case len(r.Changes) == 0:
+			fmt.Fprintln(w, " no changes")
+		default:
+			fmt.Fprintln(w)
+		}
+		for _, c := range r.Changes {
+			mark := changeMarks[c.Kind]
+			switch c.Kind {
+			case normalizer.FieldAdded:
+				fmt.Fprintf(w, "    %s %s = %s\n", mark, c.Field, c.New)
+			case normalizer.FieldRenamed:
+				fmt.Fprintf(w, "    %s %s -> %s = %s\n", mark, c.From, c.Field, c.New)
+			case normalizer.FieldOverwritten:
+				fmt.Fprintf(w, "    %s %s: %s -> %s\n", mark, c.Field, c.Old, c.New)
+			case normalizer.FieldDeleted:
+				fmt.Fprintf(w, "    %s %s (was %s)\n", mark, c.Field, c.Old)
+			}
+		}
+	}
+	if trace.Error != "" {
+		fmt.Fprintf(w, "\nquarantined: %s\n", trace.Error)
+	}
+	fmt.Fprintf(w, "\noutput: %s\n", trace.Output)
+}
+
+func init() {
+	explainCmd.Flags().StringVar(&explainOpts.rules, "rules", "", "ruleset file to use (default RULES_FILE, or the built-in ruleset)")
+	explainCmd.Flags().StringVar(&explainOpts.category, "category", "", "treat the event as this source category instead of detecting it")
+	explainCmd.Flags().BoolVar(&explainOpts.json, "json", false, "write the report as JSON")
+	rootCmd.AddCommand(explainCmd)
+}
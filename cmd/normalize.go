@@ -1,23 +1,148 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/izzatbey/soc-norm-events/internal/normalizer"
 	"github.com/spf13/cobra"
 )
 
-var serverCmd = &cobra.Command{
-	Use:   "serve",
-	Short: "Start the normalizer server",
+var normalizeOpts struct {
+	output   string
+	rules    string
+	category string
+	stage6   bool
+	pretty   bool
+	timing   bool
+}
+
+var normalizeCmd = &cobra.Command{
+	Use:   "normalize [file]",
+	Short: "Normalize events from a file or stdin without Kafka",
+	Long: `Normalize events read from file, or from stdin when no file (or "-") is
+given, and write the results to stdout or --output.
+
+Input may be a JSON array of events (like data/data.json), newline-delimited
+JSON, or any other sequence of JSON objects. Output is one normalized event
+per line, or indented JSON with --pretty. Events that fail to normalize are
+reported on stderr and skipped.`,
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		log.Printf("Starting Normalizer")
-		if err := normalizer.Run(cfg); err != nil {
-			log.Fatalf("❌ normalizer error: %v", err)
+		if normalizeOpts.rules != "" {
+			cfg.RulesFile = normalizeOpts.rules
+		}
+		if err := normalizer.ConfigureRules(cfg); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		if normalizeOpts.stage6 {
+			cfg.Enrich = true
+		}
+		if err := normalizer.ConfigureEnrichment(cfg); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		if c := normalizeOpts.category; c != "" {
+			if categories := normalizer.ActiveRuleset().Categories(); !slices.Contains(categories, c) {
+				log.Fatalf("❌ unknown category %q, expected one of: %s", c, strings.Join(categories, ", "))
+			}
+		}
+
+		in, closeIn, err := openInput(args)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		defer closeIn()
+
+		out := os.Stdout
+		if normalizeOpts.output != "" && normalizeOpts.output != "-" {
+			if out, err = os.Create(normalizeOpts.output); err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			defer out.Close()
+		}
+		w := bufio.NewWriter(out)
+		defer w.Flush()
+
+		var count, failed int
+		var total time.Duration
+		err = normalizer.ReadEvents(in, func(raw []byte) error {
+			count++
+			start := time.Now()
+			normalized, err := normalizeEvent(string(raw))
+			took := time.Since(start)
+			total += took
+
+			if normalizeOpts.timing {
+				fmt.Fprintf(os.Stderr, "event %d: %s\n", count, took)
+			}
+			if err != nil {
+				failed++
+				log.Printf("⚠️ Event %d not normalized: %v", count, err)
+				return nil
+			}
+			return writeEvent(w, normalized, normalizeOpts.pretty)
+		})
+		if err != nil {
+			w.Flush()
+			log.Fatalf("❌ event %d: %v", count+1, err)
+		}
+
+		if normalizeOpts.timing && count > 0 {
+			fmt.Fprintf(os.Stderr, "%d events (%d failed) in %s, %s per event\n",
+				count, failed, total, total/time.Duration(count))
 		}
 	},
 }
 
+// normalizeEvent applies the ruleset with the category chosen on the
+// command line, if any.
+func normalizeEvent(raw string) (string, error) {
+	if normalizeOpts.category != "" {
+		return normalizer.NormalizeAs(raw, normalizeOpts.category)
+	}
+	return normalizer.Normalize(raw)
+}
+
+// openInput opens the file named in args, or stdin.
+func openInput(args []string) (io.Reader, func(), error) {
+	if len(args) == 0 || args[0] == "-" {
+		return os.Stdin, func() {}, nil
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, func() { f.Close() }, nil
+}
+
+// writeEvent writes one event per line, or indented when pretty is set.
+func writeEvent(w io.Writer, event string, pretty bool) error {
+	if pretty {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, []byte(event), "", "  "); err == nil {
+			buf.WriteByte('\n')
+			_, err = w.Write(buf.Bytes())
+			return err
+		}
+	}
+	_, err := io.WriteString(w, event+"\n")
+	return err
+}
+
 func init() {
-	rootCmd.AddCommand(serverCmd)
+	normalizeCmd.Flags().StringVarP(&normalizeOpts.output, "output", "o", "", "write normalized events to this file instead of stdout")
+	normalizeCmd.Flags().StringVar(&normalizeOpts.rules, "rules", "", "ruleset file to use (default RULES_FILE, or the built-in ruleset)")
+	normalizeCmd.Flags().StringVar(&normalizeOpts.category, "category", "", "treat every event as this source category instead of detecting it")
+	normalizeCmd.Flags().BoolVar(&normalizeOpts.stage6, "stage6", false, "also apply stage-6 enrichment (feeds, MISP, EPSS) as configured by ENRICH_*, MISP_* and EPSS_*")
+	normalizeCmd.Flags().BoolVar(&normalizeOpts.pretty, "pretty", false, "write indented JSON instead of one event per line")
+	normalizeCmd.Flags().BoolVar(&normalizeOpts.timing, "timing", false, "print the time spent on each event to stderr")
+	rootCmd.AddCommand(normalizeCmd)
 }
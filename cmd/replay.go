@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/izzatbey/soc-norm-events/internal/normalizer"
+	"github.com/spf13/cobra"
+)
+
+var replayOpts struct {
+	topic  string
+	group  string
+	stages []string
+	limit  int
+	dryRun bool
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay-dlq",
+	Short: "Normalize dead-lettered events again and forward them to the output topic",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		opts := normalizer.ReplayOptions{
+			Topic:   replayOpts.topic,
+			GroupID: replayOpts.group,
+			Limit:   replayOpts.limit,
+			DryRun:  replayOpts.dryRun,
+			Output:  os.Stdout,
+		}
+		if opts.Topic == "" {
+			opts.Topic = cfg.DeadLetterTopic
+		}
+		if opts.GroupID == "" {
+			opts.GroupID = cfg.GroupID + "-dlq-replay"
+		}
+		for _, s := range replayOpts.stages {
+			opts.Stages = append(opts.Stages, normalizer.Stage(s))
+		}
+
+		log.Printf("Replaying dead letters from %s (group %s)", opts.Topic, opts.GroupID)
+		stats, err := normalizer.ReplayDeadLetters(ctx, cfg, opts)
+		log.Printf("Replay finished: read=%d replayed=%d skipped=%d failed=%d",
+			stats.Read, stats.Replayed, stats.Skipped, stats.Failed)
+		if err != nil {
+			log.Fatalf("❌ replay error: %v", err)
+		}
+	},
+}
+
+func init() {
+	replayCmd.Flags().StringVar(&replayOpts.topic, "topic", "", "topic to replay, e.g. KAFKA_QUARANTINE_TOPIC (default KAFKA_DLQ_TOPIC)")
+	replayCmd.Flags().StringVar(&replayOpts.group, "group", "", "consumer group tracking replay progress (default <KAFKA_GROUP_ID>-dlq-replay)")
+	replayCmd.Flags().StringSliceVar(&replayOpts.stages, "stage", nil, "only replay events that failed at these stages (parse, rule, transform, produce, delivery)")
+	replayCmd.Flags().IntVar(&replayOpts.limit, "limit", 0, "stop after this many dead letters (0 = no limit)")
+	replayCmd.Flags().BoolVar(&replayOpts.dryRun, "dry-run", false, "print normalized events to stdout instead of producing them")
+	rootCmd.AddCommand(replayCmd)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/izzatbey/soc-norm-events/internal/normalizer"
+	"github.com/spf13/cobra"
+)
+
+var serverCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Start the normalizer server",
+	Long: `Normalize events continuously from SOURCE to SINK. The default reads the
+Kafka input topic and writes the output topic; SOURCE may also be "file"
+(SOURCE_FILE, tailed unless SOURCE_FOLLOW=false), "stdin", "http" (POST to
+/ingest on HTTP_ADDR with a bearer token from HTTP_TOKENS) or "syslog"
+(RFC 3164/5424 on SYSLOG_UDP_ADDR, SYSLOG_TCP_ADDR and SYSLOG_TLS_ADDR), and
+SINK "file" (SINK_FILE), "stdout" or "http" (SINK_URL), so the same binary
+runs where there is no Kafka.
+
+The http source also answers POST /normalize with the normalized event, its
+category and the rules that ran, without writing it to the sink, so rule
+authors can check an event while they write mappings.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		log.Printf("Starting Normalizer")
+		if err := normalizer.Run(ctx, cfg); err != nil {
+			log.Fatalf("❌ normalizer error: %v", err)
+		}
+		log.Printf("Normalizer stopped")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serverCmd)
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -11,6 +12,113 @@ type Config struct {
 	InputTopic  string
 	OutputTopic string
 	GroupID     string
+
+	// DeadLetterTopic receives the original payload of events that fail
+	// normalization or delivery. Empty disables dead-lettering.
+	DeadLetterTopic string
+
+	// QuarantineTopic receives events stopped by a rule with the quarantine
+	// failure policy; empty falls back to DeadLetterTopic. RuleFailurePolicy
+	// is the policy spec, e.g. "continue,sysmonWinRemap=quarantine".
+	QuarantineTopic   string
+	RuleFailurePolicy string
+
+	// RulesFile is the YAML ruleset to normalize with; empty uses the
+	// embedded default ruleset. serve reloads it, and its lookup files,
+	// when they change.
+	RulesFile string
+
+	// CollisionPolicy decides which value a field gets when several mapped
+	// source fields are present for it, e.g. "first-wins,user.name=array".
+	CollisionPolicy string
+
+	// Workers normalize messages in parallel, each with a queue of
+	// WorkerQueueSize messages. Ordering ("partition" or "key") decides which
+	// messages share a worker and therefore keep their relative order.
+	Workers         int
+	WorkerQueueSize int
+	Ordering        string
+
+	// ShutdownTimeout bounds how long Run may spend flushing the producer
+	// and committing offsets after a shutdown signal.
+	ShutdownTimeout time.Duration
+
+	// TransactionalID enables exactly-once mode when set: normalized events
+	// and input offsets are committed together in Kafka transactions of at
+	// most TransactionBatch messages or TransactionInterval.
+	TransactionalID     string
+	TransactionBatch    int
+	TransactionInterval time.Duration
+
+	// Source and Sink select where serve reads raw events from and writes
+	// normalized events to: "kafka", "file", "stdin" or "syslog" (Source),
+	// "stdout" (Sink), or "http". A file source is tailed when SourceFollow is set.
+	// The http source listens on HTTPAddr; the http sink posts to SinkURL.
+	Source       string
+	SourceFile   string
+	SourceFollow bool
+	Sink         string
+	SinkFile     string
+	SinkURL      string
+	HTTPAddr     string
+
+	// HTTPTokens are the comma-separated bearer tokens the http source
+	// accepts; empty disables authentication. A request body may be at most
+	// HTTPMaxBodyBytes after decompression, and at most HTTPMaxRequests
+	// requests are ingested at once before the source answers 429.
+	HTTPTokens       string
+	HTTPMaxBodyBytes int64
+	HTTPMaxRequests  int
+
+	// The syslog source listens on each of these addresses that is set:
+	// UDP, TCP and TLS, the latter with the given certificate and key.
+	SyslogUDPAddr string
+	SyslogTCPAddr string
+	SyslogTLSAddr string
+	SyslogTLSCert string
+	SyslogTLSKey  string
+
+	// Enrich enables stage-6 enrichment after the rules, for the
+	// comma-separated source categories in EnrichCategories (all when
+	// empty): IOC fields are looked up in MISP, when MISPURL is set, and
+	// CVEs in EPSS, when EPSSURL is set. The MISP API key is MISPAPIKey or
+	// the content of MISPAPIKeyFile; MISPCAFile is a PEM bundle of extra CAs
+	// to trust for MISP. Up to MISPBatchSize indicators are searched per
+	// MISP request.
+	Enrich           bool
+	EnrichCategories string
+	MISPURL          string
+	MISPAPIKey       string
+	MISPAPIKeyFile   string
+	MISPCAFile       string
+	MISPTimeout      time.Duration
+	MISPIOCFields    string
+	MISPBatchSize    int
+	EPSSURL          string
+	EPSSTimeout      time.Duration
+
+	// EnrichConcurrency bounds the lookups in flight at once. An event waits
+	// at most EnrichBudget (0 for no limit) for its lookups and is emitted
+	// without those still running. Up to EnrichCacheSize results are cached for EnrichCacheTTL,
+	// or EnrichNegativeTTL for indicators the provider does not know.
+	EnrichConcurrency int
+	EnrichBudget      time.Duration
+	EnrichCacheSize   int
+	EnrichCacheTTL    time.Duration
+	EnrichNegativeTTL time.Duration
+
+	// A provider whose lookups fail EnrichBreakerFailures times in a row (0
+	// disables the breaker) is skipped for EnrichBreakerCooldown, then probed
+	// with a single lookup.
+	EnrichBreakerFailures int
+	EnrichBreakerCooldown time.Duration
+
+	// EnrichFeeds are comma-separated "[name=]glob" patterns of local
+	// threat-intel feed files (MISP feed JSON, STIX 2.1 bundles, CSV or TXT
+	// IOC lists) matched against MISPIOCFields. serve rereads changed files
+	// every EnrichFeedsRefresh (0 never).
+	EnrichFeeds        string
+	EnrichFeedsRefresh time.Duration
 }
 
 func Load() *Config {
@@ -20,14 +128,118 @@ func Load() *Config {
 	v.SetDefault("KAFKA_INPUT_TOPIC", "input-topic")
 	v.SetDefault("KAFKA_OUTPUT_TOPIC", "output-topic")
 	v.SetDefault("KAFKA_GROUP_ID", "normalizer-group")
+	v.SetDefault("KAFKA_DLQ_TOPIC", "")
+	v.SetDefault("KAFKA_QUARANTINE_TOPIC", "")
+	v.SetDefault("RULE_FAILURE_POLICY", "continue")
+	v.SetDefault("RULES_FILE", "")
+	v.SetDefault("MAPPING_COLLISION_POLICY", "first-wins")
+	v.SetDefault("WORKERS", 1)
+	v.SetDefault("WORKER_QUEUE_SIZE", 1000)
+	v.SetDefault("ORDERING_KEY", "partition")
+	v.SetDefault("SHUTDOWN_TIMEOUT", "30s")
+	v.SetDefault("KAFKA_TRANSACTIONAL_ID", "")
+	v.SetDefault("KAFKA_TRANSACTION_BATCH", 500)
+	v.SetDefault("KAFKA_TRANSACTION_INTERVAL", "1s")
+	v.SetDefault("SOURCE", "kafka")
+	v.SetDefault("SOURCE_FILE", "")
+	v.SetDefault("SOURCE_FOLLOW", true)
+	v.SetDefault("SINK", "kafka")
+	v.SetDefault("SINK_FILE", "")
+	v.SetDefault("SINK_URL", "")
+	v.SetDefault("HTTP_ADDR", ":8081")
+	v.SetDefault("HTTP_TOKENS", "")
+	v.SetDefault("HTTP_MAX_BODY_BYTES", 10<<20)
+	v.SetDefault("HTTP_MAX_REQUESTS", 16)
+	v.SetDefault("SYSLOG_UDP_ADDR", ":5514")
+	v.SetDefault("SYSLOG_TCP_ADDR", ":5514")
+	v.SetDefault("SYSLOG_TLS_ADDR", "")
+	v.SetDefault("SYSLOG_TLS_CERT", "")
+	v.SetDefault("SYSLOG_TLS_KEY", "")
+	v.SetDefault("ENRICH_ENABLED", false)
+	v.SetDefault("ENRICH_CATEGORIES", "")
+	v.SetDefault("MISP_URL", "")
+	v.SetDefault("MISP_API_KEY", "")
+	v.SetDefault("MISP_API_KEY_FILE", "")
+	v.SetDefault("MISP_CA_FILE", "")
+	v.SetDefault("MISP_TIMEOUT", "3s")
+	v.SetDefault("MISP_IOC_FIELDS", "source.ip,destination.ip,file.hash.md5,file.hash.sha1,file.hash.sha256,process.hash.sha256,dns.question.name")
+	v.SetDefault("MISP_BATCH_SIZE", 50)
+	v.SetDefault("EPSS_URL", "https://api.first.org/data/v1/epss")
+	v.SetDefault("EPSS_TIMEOUT", "3s")
+	v.SetDefault("ENRICH_CONCURRENCY", 32)
+	v.SetDefault("ENRICH_BUDGET", "500ms")
+	v.SetDefault("ENRICH_CACHE_SIZE", 10000)
+	v.SetDefault("ENRICH_CACHE_TTL", "1h")
+	v.SetDefault("ENRICH_NEGATIVE_CACHE_TTL", "10m")
+	v.SetDefault("ENRICH_BREAKER_FAILURES", 5)
+	v.SetDefault("ENRICH_BREAKER_COOLDOWN", "30s")
+	v.SetDefault("ENRICH_FEEDS", "")
+	v.SetDefault("ENRICH_FEEDS_REFRESH", "5m")
 
 	v.AutomaticEnv()
 	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 
 	return &Config{
-		Brokers:     v.GetString("KAFKA_BROKER"),
-		InputTopic:  v.GetString("KAFKA_INPUT_TOPIC"),
-		OutputTopic: v.GetString("KAFKA_OUTPUT_TOPIC"),
-		GroupID:     v.GetString("KAFKA_GROUP_ID"),
+		Brokers:         v.GetString("KAFKA_BROKER"),
+		InputTopic:      v.GetString("KAFKA_INPUT_TOPIC"),
+		OutputTopic:     v.GetString("KAFKA_OUTPUT_TOPIC"),
+		GroupID:         v.GetString("KAFKA_GROUP_ID"),
+		DeadLetterTopic: v.GetString("KAFKA_DLQ_TOPIC"),
+
+		QuarantineTopic:   v.GetString("KAFKA_QUARANTINE_TOPIC"),
+		RuleFailurePolicy: v.GetString("RULE_FAILURE_POLICY"),
+		RulesFile:         v.GetString("RULES_FILE"),
+		CollisionPolicy:   v.GetString("MAPPING_COLLISION_POLICY"),
+
+		Workers:         v.GetInt("WORKERS"),
+		WorkerQueueSize: v.GetInt("WORKER_QUEUE_SIZE"),
+		Ordering:        v.GetString("ORDERING_KEY"),
+		ShutdownTimeout: v.GetDuration("SHUTDOWN_TIMEOUT"),
+
+		TransactionalID:     v.GetString("KAFKA_TRANSACTIONAL_ID"),
+		TransactionBatch:    v.GetInt("KAFKA_TRANSACTION_BATCH"),
+		TransactionInterval: v.GetDuration("KAFKA_TRANSACTION_INTERVAL"),
+
+		Source:       v.GetString("SOURCE"),
+		SourceFile:   v.GetString("SOURCE_FILE"),
+		SourceFollow: v.GetBool("SOURCE_FOLLOW"),
+		Sink:         v.GetString("SINK"),
+		SinkFile:     v.GetString("SINK_FILE"),
+		SinkURL:      v.GetString("SINK_URL"),
+		HTTPAddr:     v.GetString("HTTP_ADDR"),
+
+		HTTPTokens:       v.GetString("HTTP_TOKENS"),
+		HTTPMaxBodyBytes: v.GetInt64("HTTP_MAX_BODY_BYTES"),
+		HTTPMaxRequests:  v.GetInt("HTTP_MAX_REQUESTS"),
+
+		SyslogUDPAddr: v.GetString("SYSLOG_UDP_ADDR"),
+		SyslogTCPAddr: v.GetString("SYSLOG_TCP_ADDR"),
+		SyslogTLSAddr: v.GetString("SYSLOG_TLS_ADDR"),
+		SyslogTLSCert: v.GetString("SYSLOG_TLS_CERT"),
+		SyslogTLSKey:  v.GetString("SYSLOG_TLS_KEY"),
+
+		Enrich:           v.GetBool("ENRICH_ENABLED"),
+		EnrichCategories: v.GetString("ENRICH_CATEGORIES"),
+		MISPURL:          v.GetString("MISP_URL"),
+		MISPAPIKey:       v.GetString("MISP_API_KEY"),
+		MISPAPIKeyFile:   v.GetString("MISP_API_KEY_FILE"),
+		MISPCAFile:       v.GetString("MISP_CA_FILE"),
+		MISPTimeout:      v.GetDuration("MISP_TIMEOUT"),
+		MISPIOCFields:    v.GetString("MISP_IOC_FIELDS"),
+		MISPBatchSize:    v.GetInt("MISP_BATCH_SIZE"),
+		EPSSURL:          v.GetString("EPSS_URL"),
+		EPSSTimeout:      v.GetDuration("EPSS_TIMEOUT"),
+
+		EnrichConcurrency: v.GetInt("ENRICH_CONCURRENCY"),
+		EnrichBudget:      v.GetDuration("ENRICH_BUDGET"),
+		EnrichCacheSize:   v.GetInt("ENRICH_CACHE_SIZE"),
+		EnrichCacheTTL:    v.GetDuration("ENRICH_CACHE_TTL"),
+		EnrichNegativeTTL: v.GetDuration("ENRICH_NEGATIVE_CACHE_TTL"),
+
+		EnrichBreakerFailures: v.GetInt("ENRICH_BREAKER_FAILURES"),
+		EnrichBreakerCooldown: v.GetDuration("ENRICH_BREAKER_COOLDOWN"),
+
+		EnrichFeeds:        v.GetString("ENRICH_FEEDS"),
+		EnrichFeedsRefresh: v.GetDuration("ENRICH_FEEDS_REFRESH"),
 	}
 }
@@ -0,0 +1,80 @@
+package normalizer
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// loadEvents returns the compacted sample events of testdata/events, keyed
+// by "<category>/<name>", plus the events of data/data.json.
+func loadEvents(tb testing.TB) map[string]string {
+	tb.Helper()
+	events := map[string]string{}
+
+	files, err := filepath.Glob(filepath.Join("testdata", "events", "*", "*.json"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, path := range files {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, raw); err != nil {
+			tb.Fatalf("%s: %v", path, err)
+		}
+		name := filepath.Base(filepath.Dir(path)) + "/" + strings.TrimSuffix(filepath.Base(path), ".json")
+		events[name] = buf.String()
+	}
+
+	raw, err := os.ReadFile(filepath.Join("..", "..", "data", "data.json"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var sample []json.RawMessage
+	if err := json.Unmarshal(raw, &sample); err != nil {
+		tb.Fatal(err)
+	}
+	for i, ev := range sample {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, ev); err != nil {
+			tb.Fatal(err)
+		}
+		events["data.json/"+strconv.Itoa(i)] = buf.String()
+	}
+	return events
+}
+
+func sortedNames(events map[string]string) []string {
+	names := make([]string, 0, len(events))
+	for name := range events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BenchmarkApplyRules measures ApplyRules on every sample event.
+// testdata/bench holds its output (-benchtime 2000x -count 6) on the
+// gjson/sjson rules that re-parsed the event per rule (before.txt) and on the
+// Document (after.txt), for comparison with benchstat.
+func BenchmarkApplyRules(b *testing.B) {
+	events := loadEvents(b)
+	for _, name := range sortedNames(events) {
+		raw := events[name]
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(raw)))
+			for i := 0; i < b.N; i++ {
+				ApplyRules(raw)
+			}
+		})
+	}
+}
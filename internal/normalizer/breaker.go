@@ -0,0 +1,98 @@
+package normalizer
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+var errBreakerOpen = errors.New("circuit breaker open")
+
+// breaker stops calls to a provider after threshold consecutive failures.
+// Once cooldown has passed it lets a single probe through (half-open): its
+// success closes the breaker again, its failure reopens it for another
+// cooldown.
+type breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+	opened   uint64
+}
+
+func newBreaker(name string, threshold int, cooldown time.Duration) *breaker {
+	return &breaker{name: name, threshold: threshold, cooldown: cooldown, state: BreakerClosed}
+}
+
+// allow reports whether a call may go ahead. A caller that is allowed must
+// report the outcome with record.
+func (b *breaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+func (b *breaker) record(err error) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		if b.state != BreakerClosed {
+			log.Printf("✅ %s circuit breaker closed", b.name)
+		}
+		b.state = BreakerClosed
+		b.failures = 0
+		b.probing = false
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		if b.state != BreakerOpen {
+			b.opened++
+			log.Printf("⚡ %s circuit breaker open for %s after %d failures: %v", b.name, b.cooldown, b.failures, err)
+		}
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+		b.probing = false
+	}
+}
+
+// status returns the state and how often the breaker has opened.
+func (b *breaker) status() (state string, opened uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, b.opened
+}
@@ -0,0 +1,107 @@
+package normalizer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/izzatbey/soc-norm-events/internal/config"
+)
+
+// Clients are the Kafka clients the normalizer runs with. NewClients builds
+// them from the configuration; tests build their own against
+// kafka.NewMockCluster.
+type Clients struct {
+	Consumer *kafka.Consumer
+	Producer *kafka.Producer
+	// Admin, if set, is used to create the topics on start.
+	Admin *kafka.AdminClient
+}
+
+// NewClients creates the consumer, producer and admin client for cfg. An
+// admin client that cannot be created is only logged: the topics may exist
+// already.
+func NewClients(cfg *config.Config) (*Clients, error) {
+	consumer, err := kafka.NewConsumer(ConsumerConfig(cfg))
+	if err != nil {
+		return nil, err
+	}
+	producer, err := kafka.NewProducer(ProducerConfig(cfg))
+	if err != nil {
+		consumer.Close()
+		return nil, fmt.Errorf("failed to create producer: %w", err)
+	}
+	admin, err := kafka.NewAdminClient(&kafka.ConfigMap{"bootstrap.servers": cfg.Brokers})
+	if err != nil {
+		log.Printf("Client Unavailable for checking : %v", err)
+		admin = nil
+	}
+	return &Clients{Consumer: consumer, Producer: producer, Admin: admin}, nil
+}
+
+// Close closes every client. The consumer goes first: leaving the group
+// revokes its partitions, and the rebalance callback still flushes the
+// producer.
+func (c *Clients) Close() {
+	c.Consumer.Close()
+	c.Producer.Close()
+	if c.Admin != nil {
+		c.Admin.Close()
+	}
+}
+
+// ConsumerConfig returns the consumer settings the normalizer relies on:
+// manual commits and, for transactional output, read_committed input.
+func ConsumerConfig(cfg *config.Config) *kafka.ConfigMap {
+	m := &kafka.ConfigMap{
+		"bootstrap.servers":  cfg.Brokers,
+		"group.id":           cfg.GroupID,
+		"auto.offset.reset":  "earliest",
+		"enable.auto.commit": false,
+	}
+	if cfg.TransactionalID != "" {
+		_ = m.SetKey("isolation.level", "read_committed")
+	}
+	return m
+}
+
+// ProducerConfig returns the producer settings of the normalizer,
+// including the transactional ID when cfg.TransactionalID is set.
+func ProducerConfig(cfg *config.Config) *kafka.ConfigMap {
+	m := &kafka.ConfigMap{
+		"bootstrap.servers":  cfg.Brokers,
+		"enable.idempotence": true,
+		"compression.type":   "lz4",
+		"linger.ms":          10,
+		"batch.num.messages": 5000,
+	}
+	if cfg.TransactionalID != "" {
+		_ = m.SetKey("transactional.id", cfg.TransactionalID)
+	}
+	return m
+}
+
+// createTopics creates the input, output and dead-letter topics if they do
+// not exist yet.
+func createTopics(ctx context.Context, cfg *config.Config, admin *kafka.AdminClient) {
+	adminCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	topics := []kafka.TopicSpecification{
+		{Topic: cfg.InputTopic, NumPartitions: 1, ReplicationFactor: 1},
+		{Topic: cfg.OutputTopic, NumPartitions: 1, ReplicationFactor: 1},
+	}
+	for _, topic := range []string{cfg.DeadLetterTopic, cfg.QuarantineTopic} {
+		if topic != "" {
+			topics = append(topics, kafka.TopicSpecification{Topic: topic, NumPartitions: 1, ReplicationFactor: 1})
+		}
+	}
+
+	if _, err := admin.CreateTopics(adminCtx, topics); err != nil {
+		log.Printf("Warning: Could not create topics: %v", err)
+	} else {
+		log.Printf("Topics Created: %s, %s", cfg.InputTopic, cfg.OutputTopic)
+	}
+}
@@ -0,0 +1,146 @@
+package normalizer
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/izzatbey/soc-norm-events/internal/config"
+	"github.com/tidwall/gjson"
+)
+
+// Stage names the step of the pipeline where an event failed.
+type Stage string
+
+const (
+	StageParse     Stage = "parse"
+	StageRule      Stage = "rule"
+	StageTransform Stage = "transform"
+	StageProduce   Stage = "produce"
+	StageDelivery  Stage = "delivery"
+)
+
+// Dead-letter message headers.
+const (
+	HeaderStage           = "dlq.stage"
+	HeaderError           = "dlq.error"
+	HeaderSourceTopic     = "dlq.source.topic"
+	HeaderSourcePartition = "dlq.source.partition"
+	HeaderSourceOffset    = "dlq.source.offset"
+	HeaderTimestamp       = "dlq.timestamp"
+)
+
+// StageError is returned by Normalize and carried to the dead-letter topic.
+type StageError struct {
+	Stage Stage
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Stage, e.Err)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// Normalize is ApplyRules with failures reported instead of swallowed: input
+// that is not valid JSON, a rule quarantining the event (see FailurePolicy),
+// and rule output that is no longer valid JSON are returned as *StageError.
+func Normalize(raw string) (string, error) {
+	return normalize(ActiveRuleset(), raw, "")
+}
+
+// NormalizeAs is Normalize with the source category forced to category
+// instead of detected by the ruleset.
+func NormalizeAs(raw, category string) (string, error) {
+	rs := ActiveRuleset()
+	if !slices.Contains(rs.Categories(), category) {
+		return "", fmt.Errorf("unknown source category %q (ruleset has %s)", category, strings.Join(rs.Categories(), ", "))
+	}
+	return normalize(rs, raw, category)
+}
+
+// Normalize is the package-level Normalize with rs instead of the active
+// ruleset, for comparing rulesets side by side.
+func (rs *Ruleset) Normalize(raw string) (string, error) {
+	return normalize(rs, raw, "")
+}
+
+func normalize(rs *Ruleset, raw, category string) (normalized string, err error) {
+	if !gjson.Valid(raw) {
+		return "", &StageError{Stage: StageParse, Err: errors.New("invalid JSON")}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			normalized = ""
+			err = &StageError{Stage: StageRule, Err: fmt.Errorf("panic: %v", r)}
+		}
+	}()
+
+	normalized, err = applyRuleset(rs, raw, category)
+	if err != nil {
+		return "", &StageError{Stage: StageRule, Err: err}
+	}
+	if !gjson.Valid(normalized) {
+		return "", &StageError{Stage: StageTransform, Err: errors.New("rules produced invalid JSON")}
+	}
+	return normalized, nil
+}
+
+// deadLetter wraps the original payload of a failed event for the
+// dead-letter topic, describing the failure in the headers.
+func deadLetter(topic string, value, key []byte, src kafka.TopicPartition, stage Stage, cause error) *kafka.Message {
+	headers := []kafka.Header{
+		{Key: HeaderStage, Value: []byte(stage)},
+		{Key: HeaderError, Value: []byte(cause.Error())},
+		{Key: HeaderTimestamp, Value: []byte(time.Now().UTC().Format(time.RFC3339Nano))},
+	}
+	if src.Topic != nil {
+		headers = append(headers,
+			kafka.Header{Key: HeaderSourceTopic, Value: []byte(*src.Topic)},
+			kafka.Header{Key: HeaderSourcePartition, Value: []byte(strconv.Itoa(int(src.Partition)))},
+			kafka.Header{Key: HeaderSourceOffset, Value: []byte(strconv.FormatInt(int64(src.Offset), 10))},
+		)
+	}
+
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Value:          value,
+		Key:            key,
+		Headers:        headers,
+	}
+}
+
+// deadLetterTopic picks the topic for events that failed at stage: rule
+// quarantines go to the quarantine topic when one is configured.
+func deadLetterTopic(cfg *config.Config, stage Stage) string {
+	if stage == StageRule && cfg.QuarantineTopic != "" {
+		return cfg.QuarantineTopic
+	}
+	return cfg.DeadLetterTopic
+}
+
+// stageOf returns the failure stage of err, defaulting to StageRule.
+func stageOf(err error) Stage {
+	var se *StageError
+	if errors.As(err, &se) {
+		return se.Stage
+	}
+	return StageRule
+}
+
+// headerValue returns the value of the first header named key.
+func headerValue(headers []kafka.Header, key string) string {
+	for _, h := range headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
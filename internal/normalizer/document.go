@@ -0,0 +1,502 @@
+package normalizer
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tidwall/gjson"
+)
+
+// Document is an event parsed once and modified in place by the rules, then
+// serialized once. Object keys keep their input order and new keys are
+// appended, so the output matches what successive sjson edits produced.
+//
+// Paths use the dotted form the rules always used with gjson/sjson
+// ("data.win.eventdata.user"); a numeric segment indexes an array and "-1"
+// appends to one when setting.
+type Document struct {
+	root any
+	undo []undoEntry
+}
+
+// object is a JSON object that remembers its key order.
+type object struct {
+	keys   []string
+	values map[string]any
+}
+
+func newObject() *object {
+	return &object{values: map[string]any{}}
+}
+
+func (o *object) get(key string) (any, bool) {
+	v, ok := o.values[key]
+	return v, ok
+}
+
+func (o *object) index(key string) int {
+	for i, k := range o.keys {
+		if k == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (o *object) set(key string, v any) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+func (o *object) insert(at int, key string, v any) {
+	if _, ok := o.values[key]; ok {
+		o.values[key] = v
+		return
+	}
+	if at < 0 || at > len(o.keys) {
+		at = len(o.keys)
+	}
+	o.keys = append(o.keys, "")
+	copy(o.keys[at+1:], o.keys[at:])
+	o.keys[at] = key
+	o.values[key] = v
+}
+
+func (o *object) remove(key string) int {
+	if _, ok := o.values[key]; !ok {
+		return -1
+	}
+	delete(o.values, key)
+	i := o.index(key)
+	o.keys = append(o.keys[:i], o.keys[i+1:]...)
+	return i
+}
+
+// undoEntry restores one key of one object to its state before an edit.
+type undoEntry struct {
+	parent  *object
+	key     string
+	old     any
+	existed bool
+	index   int
+}
+
+var errNotContainer = errors.New("path crosses a value that is not an object")
+
+// ParseDocument parses raw JSON into a Document.
+func ParseDocument(raw string) (*Document, error) {
+	if !gjson.Valid(raw) {
+		return nil, errors.New("invalid JSON")
+	}
+	return &Document{root: fromResult(gjson.Parse(raw))}, nil
+}
+
+func fromResult(r gjson.Result) any {
+	switch r.Type {
+	case gjson.String:
+		return r.Str
+	case gjson.Number:
+		return json.Number(r.Raw)
+	case gjson.True:
+		return true
+	case gjson.False:
+		return false
+	case gjson.JSON:
+		if r.IsArray() {
+			arr := []any{}
+			r.ForEach(func(_, v gjson.Result) bool {
+				arr = append(arr, fromResult(v))
+				return true
+			})
+			return arr
+		}
+		obj := newObject()
+		r.ForEach(func(k, v gjson.Result) bool {
+			obj.set(k.Str, fromResult(v))
+			return true
+		})
+		return obj
+	default:
+		return nil
+	}
+}
+
+// IsObject reports whether the document root is a JSON object.
+func (d *Document) IsObject() bool {
+	_, ok := d.root.(*object)
+	return ok
+}
+
+func splitPath(path string) []string {
+	return strings.Split(path, ".")
+}
+
+// nextSegment splits the first segment off path.
+func nextSegment(path string) (seg, rest string, more bool) {
+	return strings.Cut(path, ".")
+}
+
+func child(v any, seg string) (any, bool) {
+	switch c := v.(type) {
+	case *object:
+		return c.get(seg)
+	case []any:
+		i, err := strconv.Atoi(seg)
+		if err != nil || i < 0 || i >= len(c) {
+			return nil, false
+		}
+		return c[i], true
+	}
+	return nil, false
+}
+
+// Get returns the value at path. Objects are returned as *object, arrays as
+// []any, numbers as json.Number.
+func (d *Document) Get(path string) (any, bool) {
+	v := d.root
+	for more := true; more; {
+		var seg string
+		seg, path, more = nextSegment(path)
+		var ok bool
+		if v, ok = child(v, seg); !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// Exists reports whether path is present (a JSON null counts as present).
+func (d *Document) Exists(path string) bool {
+	_, ok := d.Get(path)
+	return ok
+}
+
+// GetString returns the value at path the way gjson's Result.String did:
+// strings as is, numbers and booleans as their JSON text, objects and arrays
+// as compact JSON, and "" for null or a missing path.
+func (d *Document) GetString(path string) string {
+	v, ok := d.Get(path)
+	if !ok {
+		return ""
+	}
+	return stringify(v)
+}
+
+func stringify(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case json.Number:
+		return string(t)
+	case bool:
+		return strconv.FormatBool(t)
+	default:
+		return string(appendJSON(nil, v))
+	}
+}
+
+// GetInt returns the value at path as an integer like gjson's Result.Int:
+// numbers are truncated, numeric strings parsed and true is 1.
+func (d *Document) GetInt(path string) int64 {
+	v, _ := d.Get(path)
+	switch t := v.(type) {
+	case json.Number:
+		return parseInt(string(t))
+	case string:
+		return parseInt(t)
+	case bool:
+		if t {
+			return 1
+		}
+	}
+	return 0
+}
+
+func parseInt(s string) int64 {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return int64(f)
+}
+
+// Keys returns the keys of the object at path in document order.
+func (d *Document) Keys(path string) []string {
+	v, _ := d.Get(path)
+	obj, ok := v.(*object)
+	if !ok {
+		return nil
+	}
+	return append([]string(nil), obj.keys...)
+}
+
+// Set stores value at path, creating intermediate objects as needed.
+// Values may be strings, numbers, booleans, nil, or values obtained from Get.
+func (d *Document) Set(path string, value any) error {
+	value = normalizeValue(value)
+	segs := splitPath(path)
+
+	if d.root == nil {
+		d.root = newObject()
+	}
+	cur := d.root
+	for i, seg := range segs {
+		last := i == len(segs)-1
+		switch c := cur.(type) {
+		case *object:
+			if last {
+				d.record(c, seg)
+				c.set(seg, value)
+				return nil
+			}
+			next, _ := c.get(seg)
+			switch next.(type) {
+			case *object, []any:
+			default:
+				// Missing or a scalar: replace it with an object, or with
+				// an array when the next segment appends.
+				d.record(c, seg)
+				if segs[i+1] == "-1" {
+					next = []any{}
+				} else {
+					next = newObject()
+				}
+				c.set(seg, next)
+			}
+			cur = next
+		case []any:
+			idx, err := strconv.Atoi(seg)
+			if err != nil {
+				return errNotContainer
+			}
+			if !last {
+				if idx < 0 || idx >= len(c) {
+					return errNotContainer
+				}
+				cur = c[idx]
+				continue
+			}
+			return d.setIndex(segs[:i], c, idx, value)
+		default:
+			return errNotContainer
+		}
+	}
+	return nil
+}
+
+// setIndex replaces element idx of arr (or appends for -1) and stores the
+// updated array back at its parent path.
+func (d *Document) setIndex(parentPath []string, arr []any, idx int, value any) error {
+	updated := append([]any(nil), arr...)
+	switch {
+	case idx == -1 || idx == len(updated):
+		updated = append(updated, value)
+	case idx >= 0 && idx < len(updated):
+		updated[idx] = value
+	default:
+		return errNotContainer
+	}
+	if len(parentPath) == 0 {
+		d.root = updated
+		return nil
+	}
+	return d.Set(strings.Join(parentPath, "."), updated)
+}
+
+// Delete removes path if present.
+func (d *Document) Delete(path string) {
+	segs := splitPath(path)
+	parentPath, key := segs[:len(segs)-1], segs[len(segs)-1]
+
+	var parent any = d.root
+	for _, seg := range parentPath {
+		var ok bool
+		if parent, ok = child(parent, seg); !ok {
+			return
+		}
+	}
+	if obj, ok := parent.(*object); ok {
+		if _, exists := obj.get(key); exists {
+			d.record(obj, key)
+			obj.remove(key)
+		}
+	}
+}
+
+// Rename moves the value at from to to and reports whether it did. The
+// value stays at from if it cannot be set at to.
+func (d *Document) Rename(from, to string) bool {
+	v, ok := d.Get(from)
+	if !ok {
+		return false
+	}
+	if from == to {
+		return true
+	}
+	if err := d.Set(to, v); err != nil {
+		return false
+	}
+	d.Delete(from)
+	return true
+}
+
+func normalizeValue(v any) any {
+	switch t := v.(type) {
+	case int:
+		return json.Number(strconv.Itoa(t))
+	case int64:
+		return json.Number(strconv.FormatInt(t, 10))
+	case float64:
+		return json.Number(strconv.FormatFloat(t, 'f', -1, 64))
+	case map[string]any:
+		obj := newObject()
+		for k, val := range t {
+			obj.set(k, normalizeValue(val))
+		}
+		return obj
+	case []string:
+		arr := make([]any, len(t))
+		for i, s := range t {
+			arr[i] = s
+		}
+		return arr
+	case *object:
+		// Copy containers so a value taken from one path and stored at
+		// another is not shared between the two.
+		obj := &object{keys: append([]string(nil), t.keys...), values: make(map[string]any, len(t.values))}
+		for k, val := range t.values {
+			obj.values[k] = normalizeValue(val)
+		}
+		return obj
+	case []any:
+		arr := make([]any, len(t))
+		for i, e := range t {
+			arr[i] = normalizeValue(e)
+		}
+		return arr
+	}
+	return v
+}
+
+func (d *Document) record(parent *object, key string) {
+	old, existed := parent.get(key)
+	d.undo = append(d.undo, undoEntry{parent: parent, key: key, old: old, existed: existed, index: parent.index(key)})
+}
+
+// mark returns a savepoint for rollback.
+func (d *Document) mark() int {
+	return len(d.undo)
+}
+
+// rollback undoes every edit made since the savepoint m.
+func (d *Document) rollback(m int) {
+	for i := len(d.undo) - 1; i >= m; i-- {
+		e := d.undo[i]
+		if e.existed {
+			if _, present := e.parent.get(e.key); present {
+				e.parent.values[e.key] = e.old
+			} else {
+				e.parent.insert(e.index, e.key, e.old)
+			}
+		} else {
+			e.parent.remove(e.key)
+		}
+	}
+	d.undo = d.undo[:m]
+}
+
+// commit forgets the undo history; edits made so far become permanent.
+func (d *Document) commit() {
+	d.undo = d.undo[:0]
+}
+
+// String serializes the document as compact JSON.
+func (d *Document) String() string {
+	return string(appendJSON(make([]byte, 0, 1024), d.root))
+}
+
+func appendJSON(b []byte, v any) []byte {
+	switch t := v.(type) {
+	case nil:
+		return append(b, "null"...)
+	case string:
+		return appendJSONString(b, t)
+	case json.Number:
+		return append(b, t...)
+	case bool:
+		return strconv.AppendBool(b, t)
+	case *object:
+		b = append(b, '{')
+		for i, k := range t.keys {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendJSONString(b, k)
+			b = append(b, ':')
+			b = appendJSON(b, t.values[k])
+		}
+		return append(b, '}')
+	case []any:
+		b = append(b, '[')
+		for i, e := range t {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendJSON(b, e)
+		}
+		return append(b, ']')
+	default:
+		raw, err := json.Marshal(t)
+		if err != nil {
+			return append(b, "null"...)
+		}
+		return append(b, raw...)
+	}
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString quotes s as a JSON string without HTML escaping, like
+// sjson did.
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= 0x20 && c != '"' && c != '\\' && c < utf8.RuneSelf {
+			b = append(b, c)
+			i++
+			continue
+		}
+		if c < utf8.RuneSelf {
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, `�`...)
+		} else {
+			b = append(b, s[i:i+size]...)
+		}
+		i += size
+	}
+	return append(b, '"')
+}
@@ -0,0 +1,178 @@
+package normalizer
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const documentEvent = `{"agent":{"name":"web-01"},"data":{"ips":["10.0.0.1",{"port":22}],"n":7,"ok":true,"none":null}}`
+
+func TestDocumentGet(t *testing.T) {
+	doc, err := ParseDocument(documentEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{
+		"agent.name":      "web-01",
+		"agent":           `{"name":"web-01"}`,
+		"data.ips":        `["10.0.0.1",{"port":22}]`,
+		"data.ips.0":      "10.0.0.1",
+		"data.ips.1.port": "22",
+		"data.n":          "7",
+		"data.ok":         "true",
+		"data.none":       "",
+	} {
+		if got := doc.GetString(path); got != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+	for _, path := range []string{"agent.id", "data.ips.2", "data.ips.-1", "data.ips.x", "data.n.x", "agent.name.x"} {
+		if doc.Exists(path) {
+			t.Errorf("%s exists", path)
+		}
+	}
+	if !doc.Exists("data.none") {
+		t.Error("a null value does not exist")
+	}
+	if got := doc.GetInt("data.n"); got != 7 {
+		t.Errorf("GetInt(data.n) = %d", got)
+	}
+	if got := doc.Keys("data"); len(got) != 4 || got[0] != "ips" || got[3] != "none" {
+		t.Errorf("Keys(data) = %q", got)
+	}
+
+	if _, err := ParseDocument(`{"a":`); err == nil {
+		t.Error("invalid JSON was parsed")
+	}
+}
+
+func TestDocumentSet(t *testing.T) {
+	for name, tc := range map[string]struct {
+		path  string
+		value any
+		want  string
+		error bool
+	}{
+		"replace":            {path: "agent.name", value: "web-02", want: `{"agent":{"name":"web-02"},"data":{"ips":["10.0.0.1",{"port":22}],"n":7,"ok":true,"none":null}}`},
+		"new key appended":   {path: "agent.id", value: 1, want: `{"agent":{"name":"web-01","id":1},"data":{"ips":["10.0.0.1",{"port":22}],"n":7,"ok":true,"none":null}}`},
+		"new object":         {path: "host.os.name", value: "linux", want: `{"agent":{"name":"web-01"},"data":{"ips":["10.0.0.1",{"port":22}],"n":7,"ok":true,"none":null},"host":{"os":{"name":"linux"}}}`},
+		"scalar replaced":    {path: "data.n.value", value: 7.5, want: `{"agent":{"name":"web-01"},"data":{"ips":["10.0.0.1",{"port":22}],"n":{"value":7.5},"ok":true,"none":null}}`},
+		"index":              {path: "data.ips.0", value: "10.0.0.2", want: `{"agent":{"name":"web-01"},"data":{"ips":["10.0.0.2",{"port":22}],"n":7,"ok":true,"none":null}}`},
+		"index into element": {path: "data.ips.1.port", value: 2222, want: `{"agent":{"name":"web-01"},"data":{"ips":["10.0.0.1",{"port":2222}],"n":7,"ok":true,"none":null}}`},
+		"append":             {path: "data.ips.-1", value: nil, want: `{"agent":{"name":"web-01"},"data":{"ips":["10.0.0.1",{"port":22},null],"n":7,"ok":true,"none":null}}`},
+		"append at length":   {path: "data.ips.2", value: true, want: `{"agent":{"name":"web-01"},"data":{"ips":["10.0.0.1",{"port":22},true],"n":7,"ok":true,"none":null}}`},
+		"new array":          {path: "tags.-1", value: "a", want: `{"agent":{"name":"web-01"},"data":{"ips":["10.0.0.1",{"port":22}],"n":7,"ok":true,"none":null},"tags":["a"]}`},
+		"map":                {path: "agent", value: map[string]any{"id": int64(3)}, want: `{"agent":{"id":3},"data":{"ips":["10.0.0.1",{"port":22}],"n":7,"ok":true,"none":null}}`},
+		"string slice":       {path: "data.ok", value: []string{"x", "y"}, want: `{"agent":{"name":"web-01"},"data":{"ips":["10.0.0.1",{"port":22}],"n":7,"ok":["x","y"],"none":null}}`},
+		"index out of range": {path: "data.ips.3", value: "x", error: true},
+		"past the end":       {path: "data.ips.5.port", value: 1, error: true},
+		"key of an array":    {path: "data.ips.port", value: 1, error: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			doc, _ := ParseDocument(documentEvent)
+			err := doc.Set(tc.path, tc.value)
+			if tc.error {
+				if err == nil {
+					t.Errorf("no error, got %s", doc)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := doc.String(); got != tc.want {
+				t.Errorf("got  %s\nwant %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDocumentSetCopies(t *testing.T) {
+	doc, _ := ParseDocument(documentEvent)
+	v, _ := doc.Get("data.ips")
+	doc.Set("copy", v)
+	doc.Set("copy.1.port", 2222)
+	if got := doc.GetString("data.ips.1.port"); got != "22" {
+		t.Errorf("editing a copy changed the original to %s", got)
+	}
+}
+
+func TestDocumentDeleteAndRename(t *testing.T) {
+	doc, _ := ParseDocument(documentEvent)
+	doc.Delete("data.n")
+	doc.Delete("data.missing")
+	doc.Delete("data.ips.0") // array elements are not deleted
+	doc.Delete("agent.name.x")
+	if got, want := doc.String(), `{"agent":{"name":"web-01"},"data":{"ips":["10.0.0.1",{"port":22}],"ok":true,"none":null}}`; got != want {
+		t.Errorf("after Delete: %s, want %s", got, want)
+	}
+
+	if doc.Rename("agent.name", "data.ips.port") || doc.GetString("agent.name") != "web-01" {
+		t.Error("Rename to a path that cannot be set removed the source")
+	}
+	if !doc.Rename("agent.name", "host.name") || doc.Rename("agent.id", "host.id") || !doc.Rename("data.ok", "data.ok") {
+		t.Error("Rename reported the wrong source presence")
+	}
+	if !doc.Rename("data.ips.1.port", "destination.port") {
+		t.Error("Rename from an array element failed")
+	}
+	if got, want := doc.String(), `{"agent":{},"data":{"ips":["10.0.0.1",{}],"ok":true,"none":null},"host":{"name":"web-01"},"destination":{"port":22}}`; got != want {
+		t.Errorf("after Rename: %s, want %s", got, want)
+	}
+}
+
+func TestDocumentJournal(t *testing.T) {
+	doc, _ := ParseDocument(documentEvent)
+	doc.Set("agent.id", "1")
+	doc.commit()
+
+	m := doc.mark()
+	doc.Set("agent.name", "changed")
+	doc.Delete("data.n")
+	doc.Set("data.ips.-1", "10.0.0.3")
+	doc.Set("new.nested.key", true)
+	doc.Rename("data.ok", "ok")
+	doc.Delete("agent.id")
+	doc.rollback(m)
+	if got, want := doc.String(), `{"agent":{"name":"web-01","id":"1"},"data":{"ips":["10.0.0.1",{"port":22}],"n":7,"ok":true,"none":null}}`; got != want {
+		t.Errorf("after rollback: %s, want %s", got, want)
+	}
+
+	// A nested savepoint only undoes what followed it.
+	doc.Set("a", 1)
+	inner := doc.mark()
+	doc.Set("b", 2)
+	doc.rollback(inner)
+	doc.Set("c", 3)
+	if !doc.Exists("a") || doc.Exists("b") || !doc.Exists("c") {
+		t.Errorf("after nested rollback: %s", doc)
+	}
+
+	// Committed edits survive a later rollback.
+	doc.commit()
+	doc.rollback(doc.mark())
+	if !doc.Exists("a") || !doc.Exists("c") {
+		t.Errorf("committed edits were undone: %s", doc)
+	}
+}
+
+func TestDocumentString(t *testing.T) {
+	doc := &Document{root: newObject()}
+	doc.Set("html", `<script>alert("x&y")</script>`)
+	doc.Set("control", "tab\tnew\nline\r\x01")
+	doc.Set("unicode", "héllo ✓ \u2028")
+	doc.Set("invalid", "a\xffb")
+	doc.Set("number", json.Number("1.50"))
+
+	want := `{"html":"<script>alert(\"x&y\")</script>","control":"tab\tnew\nline\r\u0001","unicode":"héllo ✓ ` + "\u2028" + `","invalid":"a�b","number":1.50}`
+	if got := doc.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal([]byte(doc.String()), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["html"] != `<script>alert("x&y")</script>` {
+		t.Errorf("html decoded as %q", decoded["html"])
+	}
+}
@@ -0,0 +1,506 @@
+package normalizer
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// feedSpec is one ENRICH_FEEDS entry, "[name=]pattern": the files matching
+// the glob pattern, loaded as feed name. Without a name, each file is a
+// feed named after the file.
+type feedSpec struct {
+	name    string
+	pattern string
+}
+
+func parseFeedSpecs(s string) []feedSpec {
+	var specs []feedSpec
+	for _, item := range splitList(s) {
+		name, pattern, ok := strings.Cut(item, "=")
+		if !ok {
+			name, pattern = "", item
+		}
+		specs = append(specs, feedSpec{name: strings.TrimSpace(name), pattern: strings.TrimSpace(pattern)})
+	}
+	return specs
+}
+
+// feedSet is the offline threat-intel of the enricher: indicators read from
+// local feed files, matched against the IOC fields without any network
+// access, and reloaded by refreshFeeds when the files change.
+type feedSet struct {
+	specs   []feedSpec
+	store   atomic.Pointer[indicatorStore]
+	matches atomic.Uint64
+}
+
+func newFeedSet(spec string) (*feedSet, error) {
+	f := &feedSet{specs: parseFeedSpecs(spec)}
+	if _, err := f.refresh(); err != nil {
+		return nil, fmt.Errorf("ENRICH_FEEDS: %w", err)
+	}
+	return f, nil
+}
+
+// refresh reloads the feeds if their files changed since the last load and
+// reports whether it did. On error the loaded indicators are kept.
+func (f *feedSet) refresh() (bool, error) {
+	files, err := f.files()
+	if err != nil {
+		return false, err
+	}
+	if cur := f.store.Load(); cur != nil && maps.Equal(cur.files, files) {
+		return false, nil
+	}
+	s := newIndicatorStore(files)
+	for _, spec := range f.specs {
+		paths, _ := filepath.Glob(spec.pattern)
+		paths = slices.DeleteFunc(paths, func(path string) bool {
+			_, ok := files[path]
+			return !ok
+		})
+		if len(paths) == 0 {
+			log.Printf("⚠️ No threat-intel feed files match %s", spec.pattern)
+		}
+		for _, path := range paths {
+			name := spec.name
+			if name == "" {
+				name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+			}
+			if err := s.load(name, path); err != nil {
+				return false, fmt.Errorf("feed %s: %w", path, err)
+			}
+		}
+	}
+	f.store.Store(s)
+	log.Printf("🛡️ Loaded %d threat-intel indicators from %d feed files (%d entries skipped)", s.count, len(files), s.skipped)
+	return true, nil
+}
+
+// fileStamp tells whether a feed file changed.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// files returns the feed files with their stamps. manifest.json and
+// hashes.csv of a MISP feed directory hold no indicators and are left out.
+func (f *feedSet) files() (map[string]fileStamp, error) {
+	files := map[string]fileStamp{}
+	for _, spec := range f.specs {
+		paths, err := filepath.Glob(spec.pattern)
+		if err != nil {
+			return nil, fmt.Errorf("feed pattern %q: %w", spec.pattern, err)
+		}
+		for _, path := range paths {
+			if base := filepath.Base(path); base == "manifest.json" || base == "hashes.csv" {
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			if info.Mode().IsRegular() {
+				files[path] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+			}
+		}
+	}
+	return files, nil
+}
+
+// refreshFeeds reloads the feeds of the active enricher every interval
+// until ctx is cancelled.
+func refreshFeeds(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		e := activeEnricher.Load()
+		if e == nil || e.feeds == nil {
+			continue
+		}
+		if _, err := e.feeds.refresh(); err != nil {
+			log.Printf("❌ Threat-intel feed refresh failed, keeping %d indicators: %v", e.feeds.store.Load().count, err)
+		}
+	}
+}
+
+// feedIndicator is one indicator listed by a feed.
+type feedIndicator struct {
+	feed        string
+	value       string
+	typ         string
+	description string
+	// confidence is 0-100, or -1 when the feed gives none; a zero expires
+	// never expires.
+	confidence int
+	expires    time.Time
+}
+
+// indicatorStore indexes the indicators of the feeds by type: addresses and
+// CIDR ranges by prefix, domains, URLs and file hashes by value.
+type indicatorStore struct {
+	files map[string]fileStamp
+
+	prefixes map[netip.Prefix][]*feedIndicator
+	bits     []int // prefix lengths in prefixes, longest first
+	domains  map[string][]*feedIndicator
+	urls     map[string][]*feedIndicator
+	hashes   map[string][]*feedIndicator
+
+	count   int
+	skipped int
+}
+
+func newIndicatorStore(files map[string]fileStamp) *indicatorStore {
+	return &indicatorStore{
+		files:    files,
+		prefixes: map[netip.Prefix][]*feedIndicator{},
+		domains:  map[string][]*feedIndicator{},
+		urls:     map[string][]*feedIndicator{},
+		hashes:   map[string][]*feedIndicator{},
+	}
+}
+
+// add indexes ind by the type of its value and reports whether the value
+// is a recognised indicator.
+func (s *indicatorStore) add(ind *feedIndicator) bool {
+	ind.value = strings.TrimSpace(ind.value)
+	v := ind.value
+	if prefix, ok := parsePrefix(v); ok {
+		ind.typ = "ipv4-addr"
+		if prefix.Addr().Is6() {
+			ind.typ = "ipv6-addr"
+		}
+		if !slices.Contains(s.bits, prefix.Bits()) {
+			s.bits = append(s.bits, prefix.Bits())
+			slices.SortFunc(s.bits, func(a, b int) int { return b - a })
+		}
+		s.prefixes[prefix] = append(s.prefixes[prefix], ind)
+	} else if isHash(v) {
+		ind.typ = "file"
+		s.hashes[strings.ToLower(v)] = append(s.hashes[strings.ToLower(v)], ind)
+	} else if strings.Contains(v, "://") {
+		ind.typ = "url"
+		s.urls[v] = append(s.urls[v], ind)
+	} else if domain, ok := parseDomain(v); ok {
+		ind.typ = "domain-name"
+		s.domains[domain] = append(s.domains[domain], ind)
+	} else {
+		s.skipped++
+		return false
+	}
+	s.count++
+	return true
+}
+
+// match returns the indicators for value that have not expired at now: the
+// CIDR ranges containing an address, and the listed parent domains of a
+// domain as well as the domain itself.
+func (s *indicatorStore) match(value string, now time.Time) []*feedIndicator {
+	var found []*feedIndicator
+	if addr, err := netip.ParseAddr(value); err == nil {
+		addr = addr.Unmap()
+		for _, bits := range s.bits {
+			if bits > addr.BitLen() {
+				continue
+			}
+			prefix, _ := addr.Prefix(bits)
+			found = append(found, s.prefixes[prefix]...)
+		}
+	} else if isHash(value) {
+		found = s.hashes[strings.ToLower(value)]
+	} else if strings.Contains(value, "://") {
+		found = s.urls[value]
+	} else if domain, ok := parseDomain(value); ok {
+		for {
+			found = append(found, s.domains[domain]...)
+			_, parent, _ := strings.Cut(domain, ".")
+			if !strings.Contains(parent, ".") {
+				break
+			}
+			domain = parent
+		}
+	}
+	return slices.DeleteFunc(slices.Clone(found), func(ind *feedIndicator) bool {
+		return !ind.expires.IsZero() && now.After(ind.expires)
+	})
+}
+
+func parsePrefix(v string) (netip.Prefix, bool) {
+	if addr, err := netip.ParseAddr(v); err == nil {
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), true
+	}
+	if prefix, err := netip.ParsePrefix(v); err == nil {
+		return netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()).Masked(), true
+	}
+	return netip.Prefix{}, false
+}
+
+// isHash reports whether v is an MD5, SHA-1 or SHA-256 hex digest.
+func isHash(v string) bool {
+	if len(v) != 32 && len(v) != 40 && len(v) != 64 {
+		return false
+	}
+	for _, c := range v {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+var domainPattern = regexp.MustCompile(`^(?:[a-z0-9_](?:[a-z0-9_-]*[a-z0-9])?\.)+[a-z][a-z0-9-]*[a-z0-9]$`)
+
+// parseDomain returns v as a lower-case domain name without a trailing dot.
+func parseDomain(v string) (string, bool) {
+	domain := strings.TrimSuffix(strings.ToLower(v), ".")
+	return domain, domainPattern.MatchString(domain)
+}
+
+// load adds the indicators of the feed file at path: a MISP feed export or
+// STIX 2.1 bundle (.json), an IOC list with optional confidence and expiry
+// columns (.csv), or one indicator per line (anything else).
+func (s *indicatorStore) load(feed, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		if !gjson.ValidBytes(data) {
+			return fmt.Errorf("invalid JSON")
+		}
+		if gjson.GetBytes(data, "type").String() == "bundle" {
+			s.loadSTIX(feed, data)
+			return nil
+		}
+		return s.loadMISP(feed, data)
+	case ".csv":
+		return s.loadCSV(feed, data)
+	default:
+		return s.loadText(feed, data)
+	}
+}
+
+// mispFeedTypes are the MISP attribute types loaded from a MISP feed.
+var mispFeedTypes = []string{"ip-src", "ip-dst", "ip", "domain", "hostname", "url", "link", "md5", "sha1", "sha256"}
+
+// loadMISP adds the attributes flagged for IDS of the events of a MISP feed
+// export: a single event, an array of events or a restSearch response. A
+// composite attribute such as domain|ip adds each of its parts.
+func (s *indicatorStore) loadMISP(feed string, data []byte) error {
+	var events []gjson.Result
+	switch root := gjson.ParseBytes(data); {
+	case root.Get("Event").Exists():
+		events = []gjson.Result{root.Get("Event")}
+	case root.Get("response").IsArray():
+		events = root.Get("response.#.Event").Array()
+	case root.IsArray():
+		events = root.Get("#.Event").Array()
+	default:
+		return fmt.Errorf("neither a MISP feed nor a STIX bundle")
+	}
+
+	for _, event := range events {
+		info := event.Get("info").String()
+		attrs := event.Get("Attribute").Array()
+		for _, obj := range event.Get("Object").Array() {
+			attrs = append(attrs, obj.Get("Attribute").Array()...)
+		}
+		for _, a := range attrs {
+			if !a.Get("to_ids").Bool() {
+				continue
+			}
+			types := strings.Split(a.Get("type").String(), "|")
+			values := strings.Split(a.Get("value").String(), "|")
+			for i, typ := range types {
+				if i < len(values) && slices.Contains(mispFeedTypes, typ) {
+					s.add(&feedIndicator{feed: feed, value: values[i], description: info, confidence: -1})
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// stixComparison matches the comparisons of a STIX pattern, e.g.
+// [file:hashes.'SHA-256' = '...'].
+var stixComparison = regexp.MustCompile(`([a-z0-9-]+):([A-Za-z0-9_.'-]+)\s*=\s*'((?:[^'\\]|\\.)*)'`)
+
+// loadSTIX adds the indicators of a STIX 2.1 bundle. Only patterns of
+// equality comparisons joined by OR are used, as every other comparison
+// needs more than one value to match.
+func (s *indicatorStore) loadSTIX(feed string, data []byte) {
+	for _, obj := range gjson.GetBytes(data, "objects").Array() {
+		if obj.Get("type").String() != "indicator" || obj.Get("revoked").Bool() ||
+			cmp.Or(obj.Get("pattern_type").String(), "stix") != "stix" {
+			continue
+		}
+		pattern := obj.Get("pattern").String()
+		if strings.Contains(pattern, " AND ") || strings.Contains(pattern, " FOLLOWEDBY ") {
+			s.skipped++
+			continue
+		}
+		confidence := -1
+		if c := obj.Get("confidence"); c.Exists() {
+			confidence = int(c.Int())
+		}
+		expires, _ := time.Parse(time.RFC3339, obj.Get("valid_until").String())
+		for _, m := range stixComparison.FindAllStringSubmatch(pattern, -1) {
+			object, path := m[1], m[2]
+			switch {
+			case path == "value" && slices.Contains([]string{"ipv4-addr", "ipv6-addr", "domain-name", "url"}, object):
+			case object == "file" && strings.HasPrefix(path, "hashes."):
+			default:
+				s.skipped++
+				continue
+			}
+			value := strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace(m[3])
+			s.add(&feedIndicator{
+				feed:        feed,
+				value:       value,
+				description: obj.Get("name").String(),
+				confidence:  confidence,
+				expires:     expires,
+			})
+		}
+	}
+}
+
+// loadCSV adds the indicators of a CSV list: the indicator, then optionally
+// its confidence (0-100) and expiry (RFC 3339 or a date). Rows whose first
+// column is not an indicator, such as a header, are skipped.
+func (s *indicatorStore) loadCSV(feed string, data []byte) error {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		ind := &feedIndicator{feed: feed, value: row[0], confidence: -1}
+		if len(row) > 1 {
+			if c, err := strconv.Atoi(strings.TrimSpace(row[1])); err == nil {
+				ind.confidence = c
+			}
+		}
+		if len(row) > 2 {
+			ind.expires = parseExpiry(strings.TrimSpace(row[2]))
+		}
+		s.add(ind)
+	}
+}
+
+func parseExpiry(v string) time.Time {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t
+	}
+	t, _ := time.Parse(time.DateOnly, v)
+	return t
+}
+
+// loadText adds one indicator per line, skipping blank lines and # comments.
+func (s *indicatorStore) loadText(feed string, data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		s.add(&feedIndicator{feed: feed, value: line, confidence: -1})
+	}
+	return scanner.Err()
+}
+
+// matchFeeds appends an entry to threat.enrichments for every feed
+// indicator matching an IOC field of doc.
+func (e *enricher) matchFeeds(doc *Document) {
+	if e.feeds == nil {
+		return
+	}
+	store := e.feeds.store.Load()
+	now := time.Now()
+	checked := false
+	for _, field := range e.iocFields {
+		value := doc.GetString(field)
+		if value == "" {
+			continue
+		}
+		checked = true
+		for _, ind := range store.match(value, now) {
+			e.feeds.matches.Add(1)
+			doc.Set("threat.enrichments.-1", ind.enrichment(field, value))
+		}
+	}
+	if checked {
+		doc.Set("enrichment.status.feeds", EnrichSucceeded)
+	}
+}
+
+func (ind *feedIndicator) enrichment(field, value string) any {
+	entry := &Document{root: newObject()}
+	entry.Set("matched.field", field)
+	entry.Set("matched.atomic", value)
+	entry.Set("matched.type", "indicator_match_rule")
+	entry.Set("indicator.provider", ind.feed)
+	entry.Set("indicator.type", ind.typ)
+	if ind.description != "" {
+		entry.Set("indicator.description", ind.description)
+	}
+	if ind.confidence >= 0 {
+		entry.Set("indicator.confidence", ind.confidence)
+	}
+	entry.Set("feed.name", ind.feed)
+	entry.Set("feed.indicator", ind.value)
+	if !ind.expires.IsZero() {
+		entry.Set("feed.expires", ind.expires.UTC().Format(time.RFC3339))
+	}
+	return entry.root
+}
+
+// FeedStats describes the loaded threat-intel feeds.
+type FeedStats struct {
+	Files      int
+	Indicators int
+	Matches    uint64
+}
+
+// ThreatFeedStats returns the stats of the threat-intel feeds, or false when
+// none are configured.
+func ThreatFeedStats() (FeedStats, bool) {
+	e := activeEnricher.Load()
+	if e == nil || e.feeds == nil {
+		return FeedStats{}, false
+	}
+	s := e.feeds.store.Load()
+	return FeedStats{Files: len(s.files), Indicators: s.count, Matches: e.feeds.matches.Load()}, true
+}
@@ -0,0 +1,136 @@
+package normalizer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/izzatbey/soc-norm-events/internal/config"
+)
+
+const mispFeed = `{"Event":{"info":"Phishing campaign","Attribute":[
+	{"type":"domain|ip","value":"evil.example|198.51.100.20","to_ids":true},
+	{"type":"ip-dst","value":"198.51.100.21","to_ids":false}
+],"Object":[{"Attribute":[{"type":"sha256","value":"` + sha256Hex + `","to_ids":true}]}]}}`
+
+const stixFeed = `{"type":"bundle","objects":[
+	{"type":"indicator","name":"C2 range","pattern":"[ipv4-addr:value = '203.0.113.0/24']","pattern_type":"stix","confidence":80,"valid_until":"2099-01-01T00:00:00Z"},
+	{"type":"indicator","name":"Expired","pattern":"[ipv4-addr:value = '192.0.2.1']","pattern_type":"stix","valid_until":"2000-01-01T00:00:00Z"},
+	{"type":"indicator","name":"Both","pattern":"[ipv4-addr:value = '192.0.2.2' AND ipv4-addr:value = '192.0.2.3']","pattern_type":"stix"},
+	{"type":"malware","name":"not an indicator"}
+]}`
+
+const sha256Hex = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func writeFeeds(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestIndicatorStore(t *testing.T) {
+	dir := writeFeeds(t, map[string]string{
+		"misp.json":     mispFeed,
+		"stix.json":     stixFeed,
+		"blocklist.csv": "indicator,confidence,expires\n192.0.2.50,90,2099-01-01\n192.0.2.51,50,2000-01-01\n",
+		"domains.txt":   "# bad domains\nbad.example\n\nnot an indicator\n",
+		"manifest.json": `{"uuid":{"info":"ignored"}}`,
+	})
+	feeds, err := newFeedSet(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := feeds.store.Load()
+	if len(store.files) != 4 || store.count != 8 {
+		t.Errorf("loaded %d indicators from %d files, want 8 from 4", store.count, len(store.files))
+	}
+
+	now := time.Now()
+	for _, tc := range []struct {
+		value, feed, typ string
+		confidence       int
+	}{
+		{"198.51.100.20", "misp", "ipv4-addr", -1},
+		{"www.evil.example", "misp", "domain-name", -1},
+		{sha256Hex, "misp", "file", -1},
+		{"203.0.113.99", "stix", "ipv4-addr", 80},
+		{"::ffff:192.0.2.50", "blocklist", "ipv4-addr", 90},
+		{"BAD.example.", "domains", "domain-name", -1},
+	} {
+		got := store.match(tc.value, now)
+		if len(got) != 1 || got[0].feed != tc.feed || got[0].typ != tc.typ || got[0].confidence != tc.confidence {
+			t.Errorf("%s: matched %+v", tc.value, got)
+		}
+	}
+	for _, value := range []string{"198.51.100.21", "192.0.2.1", "192.0.2.2", "192.0.2.51", "example", "evil.example.org"} {
+		if got := store.match(value, now); len(got) != 0 {
+			t.Errorf("%s: unexpected match %+v", value, got[0])
+		}
+	}
+}
+
+func TestFeedEnrichment(t *testing.T) {
+	dir := writeFeeds(t, map[string]string{"c2.json": stixFeed})
+	err := ConfigureEnrichment(&config.Config{
+		Enrich:        true,
+		EnrichFeeds:   "intel=" + filepath.Join(dir, "*.json"),
+		MISPIOCFields: "source.ip,destination.ip",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { activeEnricher.Store(nil) })
+
+	out, err := Normalize(`{"decoder":{"name":"fortigate"},"data":{"srcip":"10.0.0.1","dstip":"203.0.113.7"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, _ := ParseDocument(out)
+	for field, want := range map[string]string{
+		"threat.enrichments.0.matched.field":         "destination.ip",
+		"threat.enrichments.0.matched.atomic":        "203.0.113.7",
+		"threat.enrichments.0.indicator.provider":    "intel",
+		"threat.enrichments.0.indicator.type":        "ipv4-addr",
+		"threat.enrichments.0.indicator.confidence":  "80",
+		"threat.enrichments.0.indicator.description": "C2 range",
+		"threat.enrichments.0.feed.indicator":        "203.0.113.0/24",
+		"threat.enrichments.0.feed.expires":          "2099-01-01T00:00:00Z",
+		"enrichment.status.feeds":                    EnrichSucceeded,
+	} {
+		if got := doc.GetString(field); got != want {
+			t.Errorf("%s = %q, want %q", field, got, want)
+		}
+	}
+	if doc.Exists("threat.enrichments.1") {
+		t.Errorf("unexpected second enrichment in %s", out)
+	}
+
+	// A changed file is picked up by the next refresh, an unchanged one is
+	// not reloaded.
+	feeds := activeEnricher.Load().feeds
+	if reloaded, err := feeds.refresh(); reloaded || err != nil {
+		t.Errorf("refresh of unchanged feeds: reloaded=%t, err=%v", reloaded, err)
+	}
+	os.WriteFile(filepath.Join(dir, "more.json"), []byte(`{"Event":{"Attribute":[{"type":"ip-src","value":"10.0.0.1","to_ids":true}]}}`), 0o644)
+	if reloaded, err := feeds.refresh(); !reloaded || err != nil {
+		t.Fatalf("refresh of changed feeds: reloaded=%t, err=%v", reloaded, err)
+	}
+	out, _ = Normalize(`{"decoder":{"name":"fortigate"},"data":{"srcip":"10.0.0.1"}}`)
+	if doc, _ := ParseDocument(out); doc.GetString("threat.enrichments.0.indicator.provider") != "intel" {
+		t.Errorf("refreshed feed not matched: %s", out)
+	}
+
+	// A broken file fails the refresh and keeps the loaded indicators.
+	os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"Event":`), 0o644)
+	if _, err := feeds.refresh(); err == nil {
+		t.Error("refresh with a broken feed: no error")
+	}
+	if stats, _ := ThreatFeedStats(); stats.Indicators != 3 || stats.Matches != 2 {
+		t.Errorf("stats after failed refresh: %+v", stats)
+	}
+}
@@ -0,0 +1,138 @@
+package normalizer
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// Run a target with, for example,
+//
+//	go test ./internal/normalizer -run '^$' -fuzz FuzzApplyRules -fuzztime 1m
+//
+// Without -fuzz, go test runs every target on its seed corpus only.
+
+// addSeeds seeds f with the sample events and with the kinds of broken input
+// agents send: truncated JSON, arrays and scalars where an object is
+// expected, and huge strings.
+func addSeeds(f *testing.F) {
+	events := loadEvents(f)
+	for _, name := range sortedNames(events) {
+		raw := events[name]
+		f.Add(raw)
+		f.Add(raw[:len(raw)/2])
+	}
+	for _, raw := range []string{
+		``,
+		`{}`,
+		`[]`,
+		`null`,
+		`"event"`,
+		`[{"agent":{"name":"h3c"}}]`,
+		`{"agent":[]}`,
+		`{"agent":{"name":["nginx-ex"]},"location":"/var/log/nginx/app-access.raw.log"}`,
+		`{"rule":{"level":"high","mitre":{"tactic":{"0":"Impact"}}}}`,
+		`{"rule":{"level":99999999999999999999999}}`,
+		`{"data":{"eventdata":{"ruleName":["-"]}},"process":"N/A"}`,
+		`{"data":"-","file":null}`,
+		`{"agent":{"name":"` + strings.Repeat("x", 1<<16) + `"}}`,
+	} {
+		f.Add(raw)
+	}
+}
+
+// parseObject returns raw as a Document, or skips the input if it is not a
+// JSON object: rules only ever see objects.
+func parseObject(t *testing.T, raw string) *Document {
+	doc, err := ParseDocument(raw)
+	if err != nil || !doc.IsObject() {
+		t.Skip()
+	}
+	return doc
+}
+
+// checkRule applies fn to the event twice, outside runRule so that a panic
+// fails the target, and checks that the result is valid JSON and that the
+// second run changed nothing.
+func checkRule(t *testing.T, raw string, fn func(*Document)) {
+	doc := parseObject(t, raw)
+	fn(doc)
+	once := doc.String()
+	if !json.Valid([]byte(once)) {
+		t.Fatalf("invalid JSON output for %s:\n%s", raw, once)
+	}
+	fn(doc)
+	if twice := doc.String(); twice != once {
+		t.Fatalf("not idempotent on %s:\nonce:  %s\ntwice: %s", raw, once, twice)
+	}
+}
+
+// compiledRule returns the rule of the default ruleset named name.
+func compiledRule(f *testing.F, name string) func(*Document) {
+	r, ok := ActiveRuleset().rules[name]
+	if !ok {
+		f.Fatalf("default ruleset has no rule %s", name)
+	}
+	return r.apply
+}
+
+func FuzzApplyRules(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, raw string) {
+		out := ApplyRules(raw)
+
+		doc, err := ParseDocument(raw)
+		if err != nil || !doc.IsObject() {
+			if out != raw {
+				t.Fatalf("input that is not a JSON object was changed:\n%s\n%s", raw, out)
+			}
+			return
+		}
+		if !json.Valid([]byte(out)) {
+			t.Fatalf("invalid JSON output for %s:\n%s", raw, out)
+		}
+		if failed, _ := ParseDocument(out); failed.Exists("normalizer.failed_rules") {
+			t.Fatalf("rules failed on %s:\n%s", raw, out)
+		}
+	})
+}
+
+func FuzzExtractMitreInfo(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, raw string) {
+		doc := parseObject(t, raw)
+		for _, field := range []string{"data.eventdata.ruleName", "data.win.eventdata.ruleName"} {
+			if !doc.Exists(field) {
+				continue
+			}
+			ruleName := doc.GetString(field)
+			checkRule(t, raw, func(doc *Document) { extractMitreInfo(doc, ruleName) })
+		}
+		checkRule(t, raw, func(doc *Document) {
+			extractMitreInfo(doc, "TechniqueID=T1055,TechniqueName="+doc.GetString("agent.name"))
+		})
+	})
+}
+
+func FuzzCleanFields(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, raw string) {
+		checkRule(t, raw, cleanFields)
+	})
+}
+
+func FuzzNginxDomainRules(f *testing.F) {
+	addSeeds(f)
+	apply := compiledRule(f, "nginxDomainRules")
+	f.Fuzz(func(t *testing.T, raw string) {
+		checkRule(t, raw, apply)
+	})
+}
+
+func FuzzMapIrisSeverity(f *testing.F) {
+	addSeeds(f)
+	apply := compiledRule(f, "mapIrisSeverity")
+	f.Fuzz(func(t *testing.T, raw string) {
+		checkRule(t, raw, apply)
+	})
+}
@@ -0,0 +1,130 @@
+package normalizer
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/golden from the current rules")
+
+// TestGolden runs ApplyRules on every event of testdata/events/<category>
+// and compares the result with testdata/golden/<category>, field by field.
+// After an intentional rule change, regenerate the expected outputs with
+//
+//	go test ./internal/normalizer -run TestGolden -update
+//
+// and review the diff of testdata/golden before committing it.
+func TestGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "events", "*", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no fixtures in testdata/events")
+	}
+
+	for _, input := range inputs {
+		category := filepath.Base(filepath.Dir(input))
+		name := strings.TrimSuffix(filepath.Base(input), ".json")
+		golden := filepath.Join("testdata", "golden", category, name+".json")
+
+		t.Run(category+"/"+name, func(t *testing.T) {
+			raw, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := json.Compact(&buf, raw); err != nil {
+				t.Fatalf("%s: %v", input, err)
+			}
+
+			// The alerts fixtures are events of any source that carry
+			// alert fields; every other directory names the category the
+			// ruleset must detect.
+			if category != "alerts" {
+				if got := SourceCategory(buf.String()); got != category {
+					t.Errorf("SourceCategory = %q, want %q", got, category)
+				}
+			}
+
+			got := goldenOutput(t, ApplyRules(buf.String()))
+			if *update {
+				if err := os.MkdirAll(filepath.Dir(golden), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if diff := diffJSON(t, want, got); diff != "" {
+				t.Errorf("%s differs from the rules' output (- expected, + got):\n%s", golden, diff)
+			}
+		})
+	}
+}
+
+// goldenOutput indents a normalized event for storing in testdata/golden.
+// The ruleset hash is left out so that editing the rule file without
+// changing what it does (comments, formatting) keeps the fixtures valid.
+func goldenOutput(t *testing.T, normalized string) []byte {
+	t.Helper()
+	doc, err := ParseDocument(normalized)
+	if err != nil {
+		t.Fatalf("ApplyRules produced invalid JSON: %v\n%s", err, normalized)
+	}
+	doc.Delete("normalizer.ruleset.hash")
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(doc.String()), "", "  "); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// diffJSON compares two events field by field and describes every leaf
+// field that is missing, unexpected or different, in document order. It
+// returns "" when they are equal, key order included.
+func diffJSON(t *testing.T, want, got []byte) string {
+	t.Helper()
+	wantDoc, err := ParseDocument(string(want))
+	if err != nil {
+		t.Fatalf("invalid golden file: %v", err)
+	}
+	gotDoc, err := ParseDocument(string(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	wantLeaves, gotLeaves := leaves(wantDoc), leaves(gotDoc)
+	for _, c := range diffLeaves(wantLeaves, gotLeaves) {
+		switch c.Kind {
+		case FieldAdded:
+			fmt.Fprintf(&b, "  + %s = %s\n", c.Field, c.New)
+		case FieldDeleted:
+			fmt.Fprintf(&b, "  - %s = %s\n", c.Field, c.Old)
+		case FieldRenamed:
+			fmt.Fprintf(&b, "  - %s = %s\n  + %s = %s\n", c.From, c.New, c.Field, c.New)
+		case FieldOverwritten:
+			fmt.Fprintf(&b, "  - %s = %s\n  + %s = %s\n", c.Field, c.Old, c.Field, c.New)
+		}
+	}
+	if b.Len() == 0 && wantDoc.String() != gotDoc.String() {
+		b.WriteString("  fields are equal but in a different order:\n")
+		fmt.Fprintf(&b, "  - %s\n  + %s\n", wantDoc.String(), gotDoc.String())
+	}
+	return b.String()
+}
@@ -0,0 +1,317 @@
+package normalizer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/izzatbey/soc-norm-events/internal/config"
+)
+
+// ingestWait is how long /ingest waits for the pipeline to take an event
+// before it answers 429 and the client should retry later. Once all events
+// are taken, it waits up to ingestConfirmWait for the sink to write them
+// out before it answers 504.
+const (
+	ingestWait        = 5 * time.Second
+	ingestConfirmWait = 30 * time.Second
+)
+
+var errBusy = errors.New("normalizer is busy, retry later")
+
+// httpSource accepts events POSTed to /ingest on cfg.HTTPAddr: a single
+// JSON event, a JSON array of events or NDJSON, optionally gzip-compressed.
+// A request is answered once the sink has written out all of its events,
+// so a slow sink slows the clients down; when too many requests are open, or the
+// pipeline does not take an event within ingestWait, the answer is 429.
+// /normalize previews the normalization of an event for rule authors.
+type httpSource struct {
+	server  *http.Server
+	events  chan Event
+	tokens  [][]byte
+	maxBody int64
+	slots   chan struct{}
+}
+
+func newHTTPSource(cfg *config.Config) *httpSource {
+	s := &httpSource{
+		events:  make(chan Event),
+		maxBody: cfg.HTTPMaxBodyBytes,
+		slots:   make(chan struct{}, max(cfg.HTTPMaxRequests, 1)),
+	}
+	for _, token := range splitList(cfg.HTTPTokens) {
+		s.tokens = append(s.tokens, []byte(token))
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /ingest", s.authorized(s.ingest))
+	mux.HandleFunc("POST /normalize", s.authorized(s.preview))
+	s.server = &http.Server{Addr: cfg.HTTPAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	return s
+}
+
+func (s *httpSource) Events(ctx context.Context, handle func(Event)) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("🌐 Accepting events on http://%s/ingest", s.server.Addr)
+		if len(s.tokens) == 0 {
+			log.Printf("⚠️ HTTP_TOKENS is empty, /ingest accepts unauthenticated requests")
+		}
+		serveErr <- s.server.ListenAndServe()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			// ListenAndServe returns as soon as Shutdown starts; handle what
+			// the open requests still send until Shutdown has waited for them.
+			shutdown := make(chan struct{})
+			go func() {
+				s.server.Shutdown(shutdownCtx)
+				close(shutdown)
+			}()
+			for {
+				select {
+				case ev := <-s.events:
+					handle(ev)
+				case <-shutdown:
+					for {
+						select {
+						case ev := <-s.events:
+							handle(ev)
+						default:
+							return nil
+						}
+					}
+				}
+			}
+		case err := <-serveErr:
+			return fmt.Errorf("http source: %w", err)
+		case ev := <-s.events:
+			handle(ev)
+		}
+	}
+}
+
+// authorized rejects requests without one of the configured bearer tokens.
+func (s *httpSource) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.tokens) == 0 {
+			next(w, r)
+			return
+		}
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			for _, t := range s.tokens {
+				if subtle.ConstantTimeCompare([]byte(token), t) == 1 {
+					next(w, r)
+					return
+				}
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="normalizer"`)
+		writeJSON(w, http.StatusUnauthorized, ingestResult{Error: "missing or invalid bearer token"})
+	}
+}
+
+// body returns the request body, decompressed if needed, limited to
+// s.maxBody bytes after decompression.
+func (s *httpSource) body(w http.ResponseWriter, r *http.Request) (io.Reader, error) {
+	body := http.MaxBytesReader(w, r.Body, s.maxBody)
+	switch strings.ToLower(r.Header.Get("Content-Encoding")) {
+	case "", "identity":
+		return body, nil
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		return http.MaxBytesReader(w, gz, s.maxBody), nil
+	default:
+		return nil, errUnsupportedEncoding
+	}
+}
+
+var errUnsupportedEncoding = errors.New("unsupported Content-Encoding, expected gzip or none")
+
+// ingestResult is the response body of /ingest. Events are taken in
+// order, so after an error the first Accepted+Failed+Pending events of the
+// request need not be sent again. Pending events were taken but not yet
+// written out by the sink when the answer was sent.
+type ingestResult struct {
+	Accepted int    `json:"accepted"`
+	Failed   int    `json:"failed"`
+	Pending  int    `json:"pending,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (s *httpSource) ingest(w http.ResponseWriter, r *http.Request) {
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	default:
+		w.Header().Set("Retry-After", "1")
+		writeJSON(w, http.StatusTooManyRequests, ingestResult{Error: errBusy.Error()})
+		return
+	}
+
+	var (
+		mu      sync.Mutex
+		result  ingestResult
+		taken   int
+		pending sync.WaitGroup
+	)
+	done := func(ok bool) {
+		mu.Lock()
+		if ok {
+			result.Accepted++
+		} else {
+			result.Failed++
+		}
+		mu.Unlock()
+		pending.Done()
+	}
+	body, err := s.body(w, r)
+	if err == nil {
+		err = ReadEvents(body, func(raw []byte) error {
+			ev := Event{Value: bytes.Clone(raw), Done: done}
+			wait := time.NewTimer(ingestWait)
+			defer wait.Stop()
+			pending.Add(1)
+			select {
+			case s.events <- ev:
+				taken++
+				return nil
+			case <-wait.C:
+				pending.Done()
+				return errBusy
+			case <-r.Context().Done():
+				pending.Done()
+				return r.Context().Err()
+			}
+		})
+	}
+
+	// Wait for the sink to write out the events taken.
+	confirmed := make(chan struct{})
+	go func() {
+		pending.Wait()
+		close(confirmed)
+	}()
+	wait := time.NewTimer(ingestConfirmWait)
+	defer wait.Stop()
+	select {
+	case <-confirmed:
+	case <-wait.C:
+	case <-r.Context().Done():
+	}
+	mu.Lock()
+	res := result
+	mu.Unlock()
+	res.Pending = taken - res.Accepted - res.Failed
+
+	status := http.StatusOK
+	switch {
+	case errors.Is(err, errBusy):
+		w.Header().Set("Retry-After", "1")
+		status = http.StatusTooManyRequests
+	case err != nil:
+		status = requestStatus(err)
+	case res.Failed > 0:
+		status = http.StatusBadGateway
+	case res.Pending > 0:
+		status = http.StatusGatewayTimeout
+		err = fmt.Errorf("sink has not written %d events yet", res.Pending)
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	writeJSON(w, status, res)
+}
+
+// Preview is the response body of /normalize.
+type Preview struct {
+	Category string          `json:"category"`
+	Ruleset  string          `json:"ruleset"`
+	Rules    []string        `json:"rules"`
+	Error    string          `json:"error,omitempty"`
+	Event    json.RawMessage `json:"event"`
+	// Trace, requested with ?trace=true, lists what every rule changed.
+	Trace []RuleTrace `json:"trace,omitempty"`
+}
+
+// preview normalizes the posted event with the active ruleset and returns
+// it with the category and the rules that ran, without passing it to the
+// sink. ?category= overrides the detected category. An event copied from
+// the Wazuh dashboard's JSON view is unwrapped from its _source.
+func (s *httpSource) preview(w http.ResponseWriter, r *http.Request) {
+	body, err := s.body(w, r)
+	var raw []byte
+	if err == nil {
+		raw, err = io.ReadAll(body)
+	}
+	if err != nil {
+		writeJSON(w, requestStatus(err), Preview{Error: err.Error()})
+		return
+	}
+
+	if doc, err := ParseDocument(string(raw)); err == nil && doc.Exists("_index") {
+		if source, ok := doc.Get("_source"); ok {
+			raw = appendJSON(nil, source)
+		}
+	}
+	trace, err := Explain(string(raw), r.URL.Query().Get("category"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Preview{Error: err.Error()})
+		return
+	}
+
+	p := Preview{
+		Category: trace.Category,
+		Ruleset:  trace.Ruleset,
+		Rules:    make([]string, len(trace.Rules)),
+		Error:    trace.Error,
+		Event:    trace.Output,
+	}
+	for i, rt := range trace.Rules {
+		p.Rules[i] = rt.Rule
+	}
+	if r.URL.Query().Get("trace") == "true" {
+		p.Trace = trace.Rules
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// requestStatus is the status for a request whose body could not be read.
+func requestStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errUnsupportedEncoding):
+		return http.StatusUnsupportedMediaType
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *httpSource) Close() error {
+	return s.server.Close()
+}
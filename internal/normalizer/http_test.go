@@ -0,0 +1,209 @@
+package normalizer
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/izzatbey/soc-norm-events/internal/config"
+)
+
+// newTestHTTPSource serves s without listening on cfg.HTTPAddr and handles
+// its events, failing those whose value contains "fail".
+func newTestHTTPSource(t *testing.T, cfg *config.Config) (*httpSource, *httptest.Server) {
+	s := newHTTPSource(cfg)
+	srv := httptest.NewServer(s.server.Handler)
+	t.Cleanup(srv.Close)
+	go func() {
+		for ev := range s.events {
+			ev.Done(!bytes.Contains(ev.Value, []byte("fail")))
+		}
+	}()
+	t.Cleanup(func() { close(s.events) })
+	return s, srv
+}
+
+func TestHTTPIngest(t *testing.T) {
+	s, srv := newTestHTTPSource(t, &config.Config{
+		HTTPTokens:       "first, second",
+		HTTPMaxBodyBytes: 1024,
+		HTTPMaxRequests:  1,
+	})
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(`{"a":1}` + "\n" + `{"b":2}` + "\n"))
+	gz.Close()
+
+	for name, tc := range map[string]struct {
+		scheme, token, encoding, body string
+		status                        int
+		want                          ingestResult
+	}{
+		"no token":       {body: `{}`, status: http.StatusUnauthorized},
+		"wrong token":    {token: "third", body: `{}`, status: http.StatusUnauthorized},
+		"wrong scheme":   {scheme: "Basic", token: "first", body: `{}`, status: http.StatusUnauthorized},
+		"scheme case":    {scheme: "bearer", token: "first", body: `{"a":1}`, status: http.StatusOK, want: ingestResult{Accepted: 1}},
+		"single":         {token: "first", body: `{"a":1}`, status: http.StatusOK, want: ingestResult{Accepted: 1}},
+		"array":          {token: "second", body: `[{"a":1},{"b":2}]`, status: http.StatusOK, want: ingestResult{Accepted: 2}},
+		"gzip ndjson":    {token: "first", encoding: "gzip", body: gzipped.String(), status: http.StatusOK, want: ingestResult{Accepted: 2}},
+		"invalid json":   {token: "first", body: `{"a":1} {"b"`, status: http.StatusBadRequest, want: ingestResult{Accepted: 1}},
+		"invalid gzip":   {token: "first", encoding: "gzip", body: `{"a":1}`, status: http.StatusBadRequest},
+		"unknown coding": {token: "first", encoding: "br", body: `{}`, status: http.StatusUnsupportedMediaType},
+		"too large":      {token: "first", body: `[` + strings.Repeat(`{"a":1},`, 200) + `{}]`, status: http.StatusRequestEntityTooLarge},
+		"sink failed":    {token: "first", body: `{"a":"fail"}` + "\n" + `{"b":2}`, status: http.StatusBadGateway, want: ingestResult{Accepted: 1, Failed: 1}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, srv.URL+"/ingest", strings.NewReader(tc.body))
+			if tc.token != "" {
+				req.Header.Set("Authorization", cmp.Or(tc.scheme, "Bearer")+" "+tc.token)
+			}
+			req.Header.Set("Content-Encoding", tc.encoding)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var got ingestResult
+			json.NewDecoder(resp.Body).Decode(&got)
+			if resp.StatusCode != tc.status {
+				t.Fatalf("status %d, want %d (%+v)", resp.StatusCode, tc.status, got)
+			}
+			// How much of an oversized body is handled depends on buffering.
+			got.Error = ""
+			if tc.status != http.StatusUnauthorized && tc.status != http.StatusRequestEntityTooLarge && got != tc.want {
+				t.Errorf("result %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+
+	t.Run("busy", func(t *testing.T) {
+		s.slots <- struct{}{}
+		defer func() { <-s.slots }()
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/ingest", strings.NewReader(`{}`))
+		req.Header.Set("Authorization", "Bearer first")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+			t.Fatalf("status %d with Retry-After %q, want 429", resp.StatusCode, resp.Header.Get("Retry-After"))
+		}
+	})
+}
+
+func TestHTTPShutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	s := newHTTPSource(&config.Config{HTTPAddr: addr, HTTPMaxBodyBytes: 1024})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handled := make(chan string, 2)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- s.Events(ctx, func(ev Event) {
+			handled <- string(ev.Value)
+			ev.Done(true)
+		})
+	}()
+
+	// A request still sending events when the shutdown starts has them
+	// handled before Events returns.
+	for range 50 {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	body, send := io.Pipe()
+	answered := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Post("http://"+addr+"/ingest", "application/x-ndjson", body)
+		if err != nil {
+			t.Error(err)
+		}
+		answered <- resp
+	}()
+	send.Write([]byte(`{"a":1}` + "\n"))
+	<-handled
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	send.Write([]byte(`{"b":2}` + "\n"))
+	send.Close()
+
+	resp := <-answered
+	if resp == nil {
+		t.Fatal("request failed")
+	}
+	defer resp.Body.Close()
+	var got ingestResult
+	json.NewDecoder(resp.Body).Decode(&got)
+	if resp.StatusCode != http.StatusOK || got.Accepted != 2 {
+		t.Errorf("status %d with %+v, want both events accepted", resp.StatusCode, got)
+	}
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 1 {
+		t.Errorf("handled %d events after the shutdown started, want 1", len(handled))
+	}
+}
+
+func TestHTTPPreview(t *testing.T) {
+	_, srv := newTestHTTPSource(t, &config.Config{HTTPMaxBodyBytes: 1 << 20})
+	event := `{"decoder":{"name":"fortigate-firewall-v6"},"data":{"devname":"FGT-DC-01","srcip":"10.0.0.1"}}`
+
+	for name, tc := range map[string]struct {
+		query, body string
+		status      int
+		category    string
+	}{
+		"detected":         {body: event, status: http.StatusOK, category: "fortigate"},
+		"dashboard copy":   {body: `{"_index":"wazuh-alerts-4.x-2025.01.02","_id":"x","_source":` + event + `}`, status: http.StatusOK, category: "fortigate"},
+		"category":         {query: "&category=hostname", body: event, status: http.StatusOK, category: "hostname"},
+		"unknown category": {query: "&category=nope", body: event, status: http.StatusBadRequest},
+		"not an object":    {body: `[1]`, status: http.StatusBadRequest},
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp, err := http.Post(srv.URL+"/normalize?trace=true"+tc.query, "application/json", strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var p Preview
+			json.NewDecoder(resp.Body).Decode(&p)
+			if resp.StatusCode != tc.status {
+				t.Fatalf("status %d, want %d (%s)", resp.StatusCode, tc.status, p.Error)
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+			if p.Category != tc.category {
+				t.Errorf("category %q, want %q", p.Category, tc.category)
+			}
+			if len(p.Rules) == 0 || len(p.Trace) != len(p.Rules) {
+				t.Errorf("rules %v with %d traces", p.Rules, len(p.Trace))
+			}
+			want, _ := Explain(event, tc.category)
+			if string(p.Event) != string(want.Output) {
+				t.Errorf("event %s, want %s", p.Event, want.Output)
+			}
+		})
+	}
+}
@@ -0,0 +1,632 @@
+package normalizer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/izzatbey/soc-norm-events/internal/config"
+)
+
+// The tests in this file run the normalizer against an in-process
+// librdkafka mock cluster. They take a few seconds each and are skipped
+// with -short.
+
+var mockGroups atomic.Int64
+
+// mockKafka is a mock cluster with the normalizer's topics and a config
+// pointing at it.
+type mockKafka struct {
+	cluster *kafka.MockCluster
+	cfg     *config.Config
+}
+
+func newMockKafka(t *testing.T, partitions int) *mockKafka {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping Kafka integration test in short mode")
+	}
+	mc, err := kafka.NewMockCluster(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mc.Close)
+
+	cfg := &config.Config{
+		Brokers:             mc.BootstrapServers(),
+		InputTopic:          "raw-events",
+		OutputTopic:         "normalized-events",
+		DeadLetterTopic:     "normalizer-dlq",
+		GroupID:             fmt.Sprintf("normalizer-test-%d", mockGroups.Add(1)),
+		RuleFailurePolicy:   "continue",
+		CollisionPolicy:     "first-wins",
+		Workers:             2,
+		WorkerQueueSize:     100,
+		Ordering:            "partition",
+		ShutdownTimeout:     5 * time.Second,
+		TransactionBatch:    10,
+		TransactionInterval: 200 * time.Millisecond,
+	}
+	if err := mc.CreateTopic(cfg.InputTopic, partitions, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, topic := range []string{cfg.OutputTopic, cfg.DeadLetterTopic} {
+		if err := mc.CreateTopic(topic, 1, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &mockKafka{cluster: mc, cfg: cfg}
+}
+
+// clients creates the normalizer's clients for the mock cluster, with
+// producer settings overridden by producerOverrides.
+func (m *mockKafka) clients(t *testing.T, producerOverrides kafka.ConfigMap) *Clients {
+	t.Helper()
+	// The mock coordinator completes a rebalance only when the rebalance
+	// timeout (max.poll.interval.ms) expires; keep it short.
+	cc := ConsumerConfig(m.cfg)
+	_ = cc.SetKey("session.timeout.ms", 6000)
+	_ = cc.SetKey("heartbeat.interval.ms", 500)
+	_ = cc.SetKey("max.poll.interval.ms", 8000)
+	consumer, err := kafka.NewConsumer(cc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc := ProducerConfig(m.cfg)
+	for k, v := range producerOverrides {
+		_ = pc.SetKey(k, v)
+	}
+	producer, err := kafka.NewProducer(pc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Clients{Consumer: consumer, Producer: producer}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// start runs the normalizer until the returned stop function is called,
+// which waits for Run to return and fails the test on an error.
+func (m *mockKafka) start(t *testing.T, clients *Clients) (stop func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- RunWithClients(ctx, m.cfg, clients) }()
+
+	var stopped bool
+	stop = func() {
+		if stopped {
+			return
+		}
+		stopped = true
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("RunWithClients: %v", err)
+			}
+		case <-time.After(30 * time.Second):
+			t.Fatal("RunWithClients did not return after cancel")
+		}
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+// produce writes events to the input topic, keyed event-<first+i>, and
+// waits for them to be delivered unless wait is false.
+func (m *mockKafka) produce(t *testing.T, first int, events []string, wait bool) {
+	t.Helper()
+	p, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers":   m.cfg.Brokers,
+		"go.delivery.reports": false,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+	for i, ev := range events {
+		err := p.Produce(&kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &m.cfg.InputTopic, Partition: kafka.PartitionAny},
+			Key:            []byte(fmt.Sprintf("event-%d", first+i)),
+			Value:          []byte(ev),
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !wait {
+		return
+	}
+	if remaining := p.Flush(10000); remaining > 0 {
+		t.Fatalf("%d input events not delivered", remaining)
+	}
+}
+
+// consume reads topic from the beginning until it has n messages or the
+// timeout passes.
+func (m *mockKafka) consume(t *testing.T, topic string, n int, timeout time.Duration) []*kafka.Message {
+	t.Helper()
+	return m.read(t, topic, timeout, func(msgs []*kafka.Message) bool { return len(msgs) >= n })
+}
+
+// consumeKeys reads topic from the beginning until it has messages with n
+// distinct keys or the timeout passes, and returns the last value per key.
+// Redelivered duplicates, which at-least-once output allows, count once.
+func (m *mockKafka) consumeKeys(t *testing.T, topic string, n int, timeout time.Duration) map[string]string {
+	t.Helper()
+	var out map[string]string
+	m.read(t, topic, timeout, func(msgs []*kafka.Message) bool {
+		out = byKey(msgs)
+		return len(out) >= n
+	})
+	return out
+}
+
+// read reads topic from the beginning until enough reports true for the
+// messages read so far or the timeout passes.
+func (m *mockKafka) read(t *testing.T, topic string, timeout time.Duration, enough func([]*kafka.Message) bool) []*kafka.Message {
+	t.Helper()
+	c, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers": m.cfg.Brokers,
+		"group.id":          fmt.Sprintf("reader-%d", mockGroups.Add(1)),
+		"auto.offset.reset": "earliest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Subscribe(topic, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var msgs []*kafka.Message
+	deadline := time.Now().Add(timeout)
+	for !enough(msgs) && time.Now().Before(deadline) {
+		msg, err := c.ReadMessage(100 * time.Millisecond)
+		if err != nil {
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// committed returns the offsets committed for the input topic by the
+// normalizer's group, summed over partitions.
+func (m *mockKafka) committed(t *testing.T, partitions int) int64 {
+	t.Helper()
+	return m.groupCommitted(t, m.cfg.GroupID, m.cfg.InputTopic, partitions)
+}
+
+// groupCommitted returns the offsets committed for topic by group, summed
+// over partitions.
+func (m *mockKafka) groupCommitted(t *testing.T, group, topic string, partitions int) int64 {
+	t.Helper()
+	c, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers": m.cfg.Brokers,
+		"group.id":          group,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	tps := make([]kafka.TopicPartition, partitions)
+	for i := range tps {
+		tps[i] = kafka.TopicPartition{Topic: &topic, Partition: int32(i)}
+	}
+	offsets, err := c.Committed(tps, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, tp := range offsets {
+		if tp.Offset >= 0 {
+			total += int64(tp.Offset)
+		}
+	}
+	return total
+}
+
+// produceDeadLetters writes one dead letter per payload to topic, keyed
+// event-<i>, with the failure stage of the same index.
+func (m *mockKafka) produceDeadLetters(t *testing.T, topic string, payloads []string, stages []Stage) {
+	t.Helper()
+	p, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers":   m.cfg.Brokers,
+		"go.delivery.reports": false,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	src := kafka.TopicPartition{Topic: &m.cfg.InputTopic}
+	for i, payload := range payloads {
+		src.Offset = kafka.Offset(i)
+		msg := deadLetter(topic, []byte(payload), []byte(fmt.Sprintf("event-%d", i)), src, stages[i], errors.New("failed"))
+		if err := p.Produce(msg, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if remaining := p.Flush(10000); remaining > 0 {
+		t.Fatalf("%d dead letters not delivered", remaining)
+	}
+}
+
+// fixtureEvents returns the sample events of testdata/events in a stable
+// order.
+func fixtureEvents(t *testing.T) []string {
+	events := loadEvents(t)
+	var out []string
+	for _, name := range sortedNames(events) {
+		if !strings.HasPrefix(name, "data.json/") {
+			out = append(out, events[name])
+		}
+	}
+	return out
+}
+
+// byKey indexes messages by key.
+func byKey(msgs []*kafka.Message) map[string]string {
+	m := map[string]string{}
+	for _, msg := range msgs {
+		m[string(msg.Key)] = string(msg.Value)
+	}
+	return m
+}
+
+func TestRunNormalizesEvents(t *testing.T) {
+	m := newMockKafka(t, 1)
+	events := fixtureEvents(t)
+	m.produce(t, 0, events, true)
+
+	stop := m.start(t, m.clients(t, nil))
+	out := m.consumeKeys(t, m.cfg.OutputTopic, len(events), 20*time.Second)
+	stop()
+
+	if len(out) != len(events) {
+		t.Fatalf("got %d normalized events, want %d", len(out), len(events))
+	}
+	for i, raw := range events {
+		want, err := Normalize(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := out[fmt.Sprintf("event-%d", i)]; got != want {
+			t.Errorf("event-%d:\ngot  %s\nwant %s", i, got, want)
+		}
+	}
+	if got := m.committed(t, 1); got != int64(len(events)) {
+		t.Errorf("committed offset %d, want %d", got, len(events))
+	}
+}
+
+func TestRunDeadLettersInvalidEvents(t *testing.T) {
+	m := newMockKafka(t, 1)
+	events := fixtureEvents(t)[:2]
+	m.produce(t, 0, append(events, `{"truncated":`), true)
+
+	stop := m.start(t, m.clients(t, nil))
+	out := m.consume(t, m.cfg.OutputTopic, len(events), 20*time.Second)
+	dead := m.consume(t, m.cfg.DeadLetterTopic, 1, 20*time.Second)
+	stop()
+
+	if len(out) != len(events) {
+		t.Errorf("got %d normalized events, want %d", len(out), len(events))
+	}
+	if len(dead) != 1 {
+		t.Fatalf("got %d dead letters, want 1", len(dead))
+	}
+	if got := string(dead[0].Value); got != `{"truncated":` {
+		t.Errorf("dead letter payload %q, want the original event", got)
+	}
+	if stage := headerValue(dead[0].Headers, HeaderStage); stage != string(StageParse) {
+		t.Errorf("dead letter stage %q, want %q", stage, StageParse)
+	}
+	if got := m.committed(t, 1); got != 3 {
+		t.Errorf("committed offset %d, want 3", got)
+	}
+}
+
+func TestRunRebalance(t *testing.T) {
+	const partitions = 4
+	m := newMockKafka(t, partitions)
+	events := fixtureEvents(t)
+
+	// Two instances share the partitions, then one leaves the group and
+	// the other takes over its partitions from their committed offsets.
+	stopA := m.start(t, m.clients(t, nil))
+	b := m.clients(t, nil)
+	stopB := m.start(t, b)
+	m.produce(t, 0, events, true)
+	if got := len(m.consumeKeys(t, m.cfg.OutputTopic, len(events), 30*time.Second)); got < len(events) {
+		t.Fatalf("normalized %d events before the rebalance, want %d", got, len(events))
+	}
+	// Like Run, close the clients once stopped so B leaves the group at
+	// once rather than when its poll interval expires.
+	stopB()
+	b.Close()
+
+	m.produce(t, len(events), events, true)
+	want := int64(2 * len(events))
+	deadline := time.Now().Add(30 * time.Second)
+	for m.committed(t, partitions) < want && time.Now().Before(deadline) {
+		time.Sleep(500 * time.Millisecond)
+	}
+	stopA()
+
+	out := m.consumeKeys(t, m.cfg.OutputTopic, 2*len(events), 10*time.Second)
+	for i := 0; i < 2*len(events); i++ {
+		if _, ok := out[fmt.Sprintf("event-%d", i)]; !ok {
+			t.Errorf("event-%d was not normalized", i)
+		}
+	}
+	if got := m.committed(t, partitions); got != want {
+		t.Errorf("committed offsets %d, want %d", got, want)
+	}
+}
+
+func TestRunBrokerDown(t *testing.T) {
+	m := newMockKafka(t, 1)
+	events := fixtureEvents(t)[:4]
+
+	stop := m.start(t, m.clients(t, nil))
+	m.produce(t, 0, events, true)
+	if got := len(m.consume(t, m.cfg.OutputTopic, len(events), 20*time.Second)); got != len(events) {
+		t.Fatalf("got %d normalized events before the outage, want %d", got, len(events))
+	}
+
+	if err := m.cluster.SetBrokerDown(1); err != nil {
+		t.Fatal(err)
+	}
+	m.produce(t, len(events), events, false)
+	time.Sleep(2 * time.Second)
+	if err := m.cluster.SetBrokerUp(1); err != nil {
+		t.Fatal(err)
+	}
+
+	out := m.consumeKeys(t, m.cfg.OutputTopic, 2*len(events), 30*time.Second)
+	stop()
+	if len(out) != 2*len(events) {
+		t.Errorf("got %d normalized events after the outage, want %d", len(out), 2*len(events))
+	}
+	if got := m.committed(t, 1); got != int64(2*len(events)) {
+		t.Errorf("committed offset %d, want %d", got, 2*len(events))
+	}
+}
+
+func TestRunProduceErrorStops(t *testing.T) {
+	m := newMockKafka(t, 1)
+	small := fixtureEvents(t)[0]
+	huge := `{"agent":{"name":"` + strings.Repeat("x", 4000) + `"}}`
+	m.produce(t, 0, []string{small, huge, small}, true)
+
+	// The producer rejects the huge event and its dead letter alike, so
+	// the event is lost: Run stops with an error and its offset is never
+	// committed.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := RunWithClients(ctx, m.cfg, m.clients(t, kafka.ConfigMap{"message.max.bytes": 2000}))
+	if err == nil || ctx.Err() != nil {
+		t.Fatalf("RunWithClients returned %v, want the lost event", err)
+	}
+	if !strings.Contains(err.Error(), "@1 not delivered") {
+		t.Errorf("error %q does not name offset 1", err)
+	}
+
+	out := m.consumeKeys(t, m.cfg.OutputTopic, 1, 10*time.Second)
+	if _, ok := out["event-0"]; !ok {
+		t.Error("event-0 was not normalized")
+	}
+	if _, ok := out["event-1"]; ok {
+		t.Error("event-1 was produced despite exceeding message.max.bytes")
+	}
+	if got := m.committed(t, 1); got != 1 {
+		t.Errorf("committed offset %d, want 1 (stopped before the lost event)", got)
+	}
+}
+
+func TestRunTransactional(t *testing.T) {
+	m := newMockKafka(t, 1)
+	m.cfg.TransactionalID = "normalizer-test-txn"
+	events := fixtureEvents(t)
+	m.produce(t, 0, events, true)
+
+	stop := m.start(t, m.clients(t, nil))
+	m.consume(t, m.cfg.OutputTopic, len(events), 30*time.Second)
+	stop()
+
+	// The mock broker accepts the offsets sent with each transaction but
+	// does not store them, so only the output is checked: every event
+	// exactly once, visible to a read_committed consumer.
+	msgs := m.consume(t, m.cfg.OutputTopic, len(events)+1, 5*time.Second)
+	if len(msgs) != len(events) {
+		t.Errorf("got %d normalized events, want %d", len(msgs), len(events))
+	}
+	out := byKey(msgs)
+	for i := range events {
+		if _, ok := out[fmt.Sprintf("event-%d", i)]; !ok {
+			t.Errorf("event-%d was not normalized", i)
+		}
+	}
+}
+
+func TestRunTransactionalProduceError(t *testing.T) {
+	m := newMockKafka(t, 1)
+	m.cfg.TransactionalID = "normalizer-test-txn-produce"
+	small := fixtureEvents(t)[0]
+	huge := `{"agent":{"name":"` + strings.Repeat("x", 4000) + `"}}`
+	m.produce(t, 0, []string{small, huge, small}, true)
+
+	// The huge event cannot be produced, nor dead-lettered; aborting would
+	// rewind to it and fail again forever, so it is skipped.
+	stop := m.start(t, m.clients(t, kafka.ConfigMap{"message.max.bytes": 2000}))
+	m.consume(t, m.cfg.OutputTopic, 2, 20*time.Second)
+	stop()
+
+	msgs := m.consume(t, m.cfg.OutputTopic, 3, 5*time.Second)
+	out := byKey(msgs)
+	if len(msgs) != 2 || out["event-0"] == "" || out["event-2"] == "" {
+		t.Errorf("got %d events %v, want event-0 and event-2 once", len(msgs), out)
+	}
+}
+
+func TestRunPipelineKafkaToFile(t *testing.T) {
+	m := newMockKafka(t, 1)
+	events := fixtureEvents(t)
+	m.produce(t, 0, events, true)
+
+	m.cfg.Source = SourceKafka
+	m.cfg.Sink = SinkFile
+	m.cfg.SinkFile = filepath.Join(t.TempDir(), "normalized.ndjson")
+	source, err := NewSource(m.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	sink, err := NewSink(m.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- RunPipeline(ctx, m.cfg, source, sink) }()
+
+	var lines []string
+	deadline := time.Now().Add(20 * time.Second)
+	for len(lines) < len(events) && time.Now().Before(deadline) {
+		time.Sleep(200 * time.Millisecond)
+		sink.Flush(0)
+		out, _ := os.ReadFile(m.cfg.SinkFile)
+		lines = strings.FieldsFunc(string(out), func(r rune) bool { return r == '\n' })
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	sink.Close()
+
+	if len(lines) != len(events) {
+		t.Fatalf("got %d normalized events, want %d", len(lines), len(events))
+	}
+	for i, raw := range events {
+		want, _ := Normalize(raw)
+		if lines[i] != want {
+			t.Errorf("event %d:\ngot  %s\nwant %s", i, lines[i], want)
+		}
+	}
+}
+
+func TestRunPipelineDeadLetters(t *testing.T) {
+	m := newMockKafka(t, 1)
+	events := fixtureEvents(t)
+	dir := t.TempDir()
+	m.cfg.Source = SourceFile
+	m.cfg.SourceFile = filepath.Join(dir, "raw.ndjson")
+	m.cfg.Sink = SinkFile
+	m.cfg.SinkFile = filepath.Join(dir, "normalized.ndjson")
+	os.WriteFile(m.cfg.SourceFile, []byte(events[0]+"\n{\"a\":\n"+events[1]+"\n"), 0o644)
+
+	source, err := NewSource(m.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	sink, err := NewSink(m.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := RunPipeline(context.Background(), m.cfg, source, sink); err != nil {
+		t.Fatal(err)
+	}
+
+	// The invalid event goes to the dead-letter topic, the others to the sink.
+	out, _ := os.ReadFile(m.cfg.SinkFile)
+	if n := strings.Count(string(out), "\n"); n != 2 {
+		t.Errorf("got %d normalized events, want 2", n)
+	}
+	dead := m.consume(t, m.cfg.DeadLetterTopic, 1, 10*time.Second)
+	if len(dead) != 1 || string(dead[0].Value) != `{"a":` {
+		t.Fatalf("got %d dead letters, want the invalid event", len(dead))
+	}
+	if stage := headerValue(dead[0].Headers, HeaderStage); stage != string(StageParse) {
+		t.Errorf("dead letter stage %q, want %q", stage, StageParse)
+	}
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+	m := newMockKafka(t, 1)
+	events := fixtureEvents(t)
+	m.produceDeadLetters(t, m.cfg.DeadLetterTopic,
+		[]string{events[0], events[1], `{"a":`, events[2]},
+		[]Stage{StageParse, StageDelivery, StageParse, StageParse})
+	ctx := context.Background()
+
+	// Only parse failures: event-1 is skipped and event-2 still fails, so
+	// progress is committed up to event-1 only.
+	opts := ReplayOptions{GroupID: "replay", Stages: []Stage{StageParse}}
+	stats, err := ReplayDeadLetters(ctx, m.cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ReplayStats{Read: 4, Replayed: 2, Skipped: 1, Failed: 1}); stats != want {
+		t.Errorf("stats %+v, want %+v", stats, want)
+	}
+	out := m.consumeKeys(t, m.cfg.OutputTopic, 2, 10*time.Second)
+	if len(out) != 2 || out["event-0"] == "" || out["event-3"] == "" {
+		t.Errorf("replayed %v, want event-0 and event-3", out)
+	}
+	if got := m.groupCommitted(t, "replay", m.cfg.DeadLetterTopic, 1); got != 1 {
+		t.Errorf("replay position %d, want 1 (the skipped event-1)", got)
+	}
+
+	// Replaying every stage commits up to the failing event-2. (A second
+	// member of the same group would wait out the mock coordinator's
+	// rebalance timeout.)
+	stats, err = ReplayDeadLetters(ctx, m.cfg, ReplayOptions{GroupID: "replay-all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ReplayStats{Read: 4, Replayed: 3, Failed: 1}); stats != want {
+		t.Errorf("stats %+v, want %+v", stats, want)
+	}
+	if out := m.consumeKeys(t, m.cfg.OutputTopic, 3, 10*time.Second); out["event-1"] == "" {
+		t.Errorf("event-1 not replayed: %v", out)
+	}
+	if got := m.groupCommitted(t, "replay-all", m.cfg.DeadLetterTopic, 1); got != 2 {
+		t.Errorf("replay position %d, want 2 (the failing event-2)", got)
+	}
+}
+
+func TestReplayQuarantine(t *testing.T) {
+	m := newMockKafka(t, 1)
+	m.cfg.QuarantineTopic = "normalizer-quarantine"
+	if err := m.cluster.CreateTopic(m.cfg.QuarantineTopic, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	m.produceDeadLetters(t, m.cfg.QuarantineTopic, fixtureEvents(t)[:1], []Stage{StageRule})
+
+	stats, err := ReplayDeadLetters(context.Background(), m.cfg, ReplayOptions{Topic: m.cfg.QuarantineTopic, GroupID: "replay"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (ReplayStats{Read: 1, Replayed: 1}) {
+		t.Errorf("stats %+v, want the quarantined event replayed", stats)
+	}
+	if out := m.consumeKeys(t, m.cfg.OutputTopic, 1, 10*time.Second); out["event-0"] == "" {
+		t.Errorf("quarantined event not replayed: %v", out)
+	}
+	if got := m.groupCommitted(t, "replay", m.cfg.QuarantineTopic, 1); got != 1 {
+		t.Errorf("replay position %d, want 1", got)
+	}
+}
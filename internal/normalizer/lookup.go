@@ -0,0 +1,120 @@
+package normalizer
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lookupResult is the outcome of one enrichment lookup. Found is false for
+// an indicator the provider does not know, which is cached like a hit.
+type lookupResult struct {
+	Value any
+	Found bool
+}
+
+// lookupCache is an LRU cache of lookup results that expire after ttl, or
+// negativeTTL for results that found nothing.
+type lookupCache struct {
+	mu          sync.Mutex
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	order       *list.List
+	entries     map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	result  lookupResult
+	expires time.Time
+}
+
+func newLookupCache(size int, ttl, negativeTTL time.Duration) *lookupCache {
+	return &lookupCache{
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		order:       list.New(),
+		entries:     map[string]*list.Element{},
+	}
+}
+
+func (c *lookupCache) get(key string) (lookupResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return lookupResult{}, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return lookupResult{}, false
+	}
+	c.order.MoveToFront(el)
+	return entry.result, true
+}
+
+func (c *lookupCache) add(key string, result lookupResult) {
+	ttl := c.ttl
+	if !result.Found {
+		ttl = c.negativeTTL
+	}
+	if c.size <= 0 || ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &cacheEntry{key: key, result: result, expires: time.Now().Add(ttl)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// flightGroup coalesces concurrent lookups of the same key into one.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	done   chan struct{}
+	result lookupResult
+	err    error
+}
+
+// start returns the call in flight for key and false, or registers a new
+// one and returns it with true: the caller is then the leader and must
+// finish it.
+func (g *flightGroup) start(key string) (*flight, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.calls == nil {
+		g.calls = map[string]*flight{}
+	}
+	if f, ok := g.calls[key]; ok {
+		return f, false
+	}
+	f := &flight{done: make(chan struct{})}
+	g.calls[key] = f
+	return f, true
+}
+
+// finish completes the call f for key, releasing everyone waiting for it.
+func (g *flightGroup) finish(key string, f *flight, result lookupResult, err error) {
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	f.result, f.err = result, err
+	close(f.done)
+}
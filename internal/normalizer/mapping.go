@@ -0,0 +1,169 @@
+package normalizer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// fieldMapping maps one source field to a target field. Mapping tables are
+// slices of fieldMapping applied in order, so when several sources map to
+// the same target the earlier entry takes precedence.
+type fieldMapping struct {
+	from string
+	to   string
+}
+
+// CollisionPolicy decides what happens when a second source of the same
+// mapping table is present for a target that an earlier entry already set.
+type CollisionPolicy string
+
+const (
+	// CollisionFirstWins keeps the value of the earlier entry.
+	CollisionFirstWins CollisionPolicy = "first-wins"
+	// CollisionLastWins overwrites it with the value of the later entry.
+	CollisionLastWins CollisionPolicy = "last-wins"
+	// CollisionArray turns the target into an array of the distinct values,
+	// in table order.
+	CollisionArray CollisionPolicy = "array"
+	// CollisionConflict keeps the earlier value and records the losing one
+	// in conflictsField.
+	CollisionConflict CollisionPolicy = "conflict"
+)
+
+// conflictsField lists the values dropped by CollisionConflict as objects
+// {"field", "source", "value"}.
+const conflictsField = "normalizer.conflicts"
+
+var (
+	collisionMu       sync.RWMutex
+	defaultCollision  = CollisionFirstWins
+	collisionOverride = map[string]CollisionPolicy{}
+)
+
+// SetCollisionPolicies configures the mapping collision policies from a spec
+// such as "first-wins,user.name=array": a bare policy sets the default,
+// target=policy overrides it for one target field.
+func SetCollisionPolicies(spec string) error {
+	def := CollisionFirstWins
+	overrides := map[string]CollisionPolicy{}
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		field, value, hasField := strings.Cut(part, "=")
+		if !hasField {
+			field, value = "", field
+		}
+		policy := CollisionPolicy(strings.ToLower(strings.TrimSpace(value)))
+		switch policy {
+		case CollisionFirstWins, CollisionLastWins, CollisionArray, CollisionConflict:
+		default:
+			return fmt.Errorf("unknown mapping collision policy %q", value)
+		}
+		if field = strings.TrimSpace(field); field == "" {
+			def = policy
+		} else {
+			overrides[field] = policy
+		}
+	}
+
+	collisionMu.Lock()
+	defer collisionMu.Unlock()
+	defaultCollision = def
+	collisionOverride = overrides
+	return nil
+}
+
+func collisionPolicy(target string) CollisionPolicy {
+	collisionMu.RLock()
+	defer collisionMu.RUnlock()
+	if p, ok := collisionOverride[target]; ok {
+		return p
+	}
+	return defaultCollision
+}
+
+// renameFields moves every present source field of mapping to its target.
+func renameFields(doc *Document, mapping []fieldMapping) {
+	applyMapping(doc, mapping, true)
+}
+
+// copyFields copies every present source field of mapping to its target,
+// leaving the source in place.
+func copyFields(doc *Document, mapping []fieldMapping) {
+	applyMapping(doc, mapping, false)
+}
+
+// applyMapping applies mapping in order. Only targets set earlier in the
+// same pass count as collisions; a value the event already had at the target
+// is overwritten. With move, a source is removed even when its value loses
+// the collision.
+func applyMapping(doc *Document, mapping []fieldMapping, move bool) {
+	var (
+		written map[string]bool
+		arrays  map[string]bool
+	)
+	for _, m := range mapping {
+		if m.from == m.to {
+			continue
+		}
+		v, ok := doc.Get(m.from)
+		if !ok {
+			continue
+		}
+
+		if !written[m.to] {
+			doc.Set(m.to, v)
+			if written == nil {
+				written = map[string]bool{}
+			}
+			written[m.to] = true
+		} else {
+			switch collisionPolicy(m.to) {
+			case CollisionLastWins:
+				doc.Set(m.to, v)
+			case CollisionArray:
+				if arrays == nil {
+					arrays = map[string]bool{}
+				}
+				if appendDistinct(doc, m.to, v, !arrays[m.to]) {
+					arrays[m.to] = true
+				}
+			case CollisionConflict:
+				conflict := newObject()
+				conflict.set("field", m.to)
+				conflict.set("source", m.from)
+				conflict.set("value", v)
+				doc.Set(conflictsField+".-1", conflict)
+			}
+		}
+
+		if move {
+			doc.Delete(m.from)
+		}
+	}
+}
+
+// appendDistinct adds v to the array at path unless an equal value is
+// already there and reports whether the array was stored. With wrap, the
+// current value is not yet an array and becomes its first element.
+func appendDistinct(doc *Document, path string, v any, wrap bool) bool {
+	cur, _ := doc.Get(path)
+	values := []any{cur}
+	if !wrap {
+		values, _ = cur.([]any)
+	}
+
+	encoded := appendJSON(nil, v)
+	for _, existing := range values {
+		if bytes.Equal(appendJSON(nil, existing), encoded) {
+			return false
+		}
+	}
+	doc.Set(path, append(values, v))
+	return true
+}
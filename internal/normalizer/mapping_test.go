@@ -0,0 +1,114 @@
+package normalizer
+
+import "testing"
+
+func TestApplyMapping(t *testing.T) {
+	t.Cleanup(func() { SetCollisionPolicies("") })
+
+	// Two sources of the same target, as in the per-decoder mapping tables.
+	mapping := []fieldMapping{
+		{from: "data.srcuser", to: "user.name"},
+		{from: "data.user", to: "user.name"},
+	}
+	const event = `{"data":{"srcuser":"alice","user":"bob"}}`
+
+	for name, tc := range map[string]struct {
+		spec, event string
+		move        bool
+		want        map[string]string
+	}{
+		"first wins": {spec: "first-wins", event: event, move: true, want: map[string]string{
+			"user.name": "alice", "data": "{}",
+		}},
+		"last wins": {spec: "last-wins", event: event, move: true, want: map[string]string{
+			"user.name": "bob", "data": "{}",
+		}},
+		"array": {spec: "array", event: event, want: map[string]string{
+			"user.name": `["alice","bob"]`, "data.user": "bob",
+		}},
+		"array of equal values": {spec: "array", event: `{"data":{"srcuser":"alice","user":"alice"}}`, want: map[string]string{
+			"user.name": "alice",
+		}},
+		"conflict": {spec: "conflict", event: event, move: true, want: map[string]string{
+			"user.name":            "alice",
+			"normalizer.conflicts": `[{"field":"user.name","source":"data.user","value":"bob"}]`,
+		}},
+		"override": {spec: "last-wins,user.name=first-wins", event: event, move: true, want: map[string]string{
+			"user.name": "alice",
+		}},
+		"one source": {spec: "conflict", event: `{"data":{"user":"bob"}}`, move: true, want: map[string]string{
+			"user.name": "bob", "normalizer.conflicts": "",
+		}},
+		"existing target": {spec: "first-wins", event: `{"user":{"name":"root"},"data":{"user":"bob"}}`, move: true, want: map[string]string{
+			"user.name": "bob",
+		}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := SetCollisionPolicies(tc.spec); err != nil {
+				t.Fatal(err)
+			}
+			doc, _ := ParseDocument(tc.event)
+			applyMapping(doc, mapping, tc.move)
+			for field, want := range tc.want {
+				if got := doc.GetString(field); got != want {
+					t.Errorf("%s = %s, want %s in %s", field, got, want, doc)
+				}
+			}
+		})
+	}
+}
+
+func TestAppendDistinct(t *testing.T) {
+	doc, _ := ParseDocument(`{"ip":"10.0.0.1","ips":["10.0.0.1",{"a":1}]}`)
+	for _, tc := range []struct {
+		path  string
+		value any
+		wrap  bool
+		added bool
+		want  string
+	}{
+		{"ip", "10.0.0.1", true, false, `"10.0.0.1"`},
+		{"ip", "10.0.0.2", true, true, `["10.0.0.1","10.0.0.2"]`},
+		{"ips", "10.0.0.1", false, false, `["10.0.0.1",{"a":1}]`},
+		{"ips", map[string]any{"a": 1}, false, false, `["10.0.0.1",{"a":1}]`},
+		{"ips", 2, false, true, `["10.0.0.1",{"a":1},2]`},
+	} {
+		v, _ := doc.Get(tc.path)
+		before := string(appendJSON(nil, v))
+		if added := appendDistinct(doc, tc.path, tc.value, tc.wrap); added != tc.added {
+			t.Errorf("appending %v to %s: added=%t, want %t", tc.value, before, added, tc.added)
+		}
+		if v, _ := doc.Get(tc.path); string(appendJSON(nil, v)) != tc.want {
+			t.Errorf("appending %v to %s gave %s, want %s", tc.value, before, appendJSON(nil, v), tc.want)
+		}
+	}
+}
+
+func TestSetCollisionPolicies(t *testing.T) {
+	t.Cleanup(func() { SetCollisionPolicies("") })
+
+	if err := SetCollisionPolicies(" Array , user.name=CONFLICT,source.ip = last-wins"); err != nil {
+		t.Fatal(err)
+	}
+	for target, want := range map[string]CollisionPolicy{
+		"user.name": CollisionConflict,
+		"source.ip": CollisionLastWins,
+		"host.name": CollisionArray,
+	} {
+		if got := collisionPolicy(target); got != want {
+			t.Errorf("policy of %s = %s, want %s", target, got, want)
+		}
+	}
+
+	for _, spec := range []string{"newest", "user.name=merge"} {
+		if err := SetCollisionPolicies(spec); err == nil {
+			t.Errorf("%q: no error", spec)
+		}
+	}
+	if got := collisionPolicy("user.name"); got != CollisionConflict {
+		t.Errorf("policy after a bad spec = %s, want the previous one", got)
+	}
+	if err := SetCollisionPolicies(""); err != nil || collisionPolicy("user.name") != CollisionFirstWins {
+		t.Errorf("empty spec: %v, policy %s", err, collisionPolicy("user.name"))
+	}
+}
@@ -0,0 +1,67 @@
+package normalizer
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ruleFailures counts recovered rule panics per rule name.
+var ruleFailures sync.Map
+
+func recordRuleFailure(name string) {
+	counter, _ := ruleFailures.LoadOrStore(name, new(uint64))
+	atomic.AddUint64(counter.(*uint64), 1)
+}
+
+// RuleFailureCounts returns the number of recovered panics per rule since
+// start-up.
+func RuleFailureCounts() map[string]uint64 {
+	counts := map[string]uint64{}
+	ruleFailures.Range(func(key, value any) bool {
+		counts[key.(string)] = atomic.LoadUint64(value.(*uint64))
+		return true
+	})
+	return counts
+}
+
+// reportMetrics logs throughput every five seconds until ctx is cancelled.
+func reportMetrics(ctx context.Context, msgCount *uint64) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	var last uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		cur := atomic.LoadUint64(msgCount)
+		rate := float64(cur-last) / 5.0
+		last = cur
+		log.Printf("[Metrics] %.2f msg/sec (total=%d)", rate, cur)
+		log.Printf("[Metrics] ruleset %s reloads=%d failed_reloads=%d", ActiveRuleset(),
+			atomic.LoadUint64(&rulesetReloads), atomic.LoadUint64(&rulesetReloadFailures))
+
+		failures := RuleFailureCounts()
+		names := make([]string, 0, len(failures))
+		for name := range failures {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			log.Printf("[Metrics] rule %s failures=%d", name, failures[name])
+		}
+
+		for _, p := range EnrichmentStats() {
+			log.Printf("[Metrics] enrichment %s breaker=%s opened=%d succeeded=%d failed=%d skipped=%d timed_out=%d cache_hits=%d",
+				p.Provider, p.Breaker, p.BreakerOpened, p.Succeeded, p.Failed, p.Skipped, p.TimedOut, p.CacheHits)
+		}
+		if f, ok := ThreatFeedStats(); ok {
+			log.Printf("[Metrics] feeds files=%d indicators=%d matches=%d", f.Files, f.Indicators, f.Matches)
+		}
+	}
+}
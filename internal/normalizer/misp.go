@@ -0,0 +1,133 @@
+package normalizer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// maxMISPMatches bounds the attributes reported for one indicator.
+const maxMISPMatches = 10
+
+// mispAttribute is a MISP attribute matching an indicator.
+type mispAttribute struct {
+	id, eventID, eventInfo string
+	typ, category          string
+	toIDs                  bool
+	firstSeen, lastSeen    string
+	tags, galaxies         []string
+	tlp                    string
+}
+
+// fetchMISP searches MISP attributes for values with one restSearch POST.
+func (e *enricher) fetchMISP(ctx context.Context, values []string) (map[string]lookupResult, error) {
+	query, _ := json.Marshal(map[string]any{
+		"returnFormat":     "json",
+		"value":            values,
+		"includeEventTags": true,
+	})
+	req, err := http.NewRequestWithContext(ctx, "POST", e.mispURL+"/attributes/restSearch", bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", e.mispKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Go-Normalizer-MISP-Lookup")
+
+	body, err := fetch(e.client, req)
+	if err != nil {
+		return nil, err
+	}
+	return parseMISPAttributes(body, values), nil
+}
+
+// parseMISPAttributes assigns the attributes of a restSearch response to
+// the values they match. A composite attribute such as ip-dst|port matches
+// each of its parts.
+func parseMISPAttributes(body []byte, values []string) map[string]lookupResult {
+	matches := make(map[string][]mispAttribute, len(values))
+	for _, a := range gjson.GetBytes(body, "response.Attribute").Array() {
+		attr := mispAttribute{
+			id:        a.Get("id").String(),
+			eventID:   a.Get("event_id").String(),
+			eventInfo: a.Get("Event.info").String(),
+			typ:       a.Get("type").String(),
+			category:  a.Get("category").String(),
+			toIDs:     a.Get("to_ids").Bool(),
+			firstSeen: a.Get("first_seen").String(),
+			lastSeen:  a.Get("last_seen").String(),
+		}
+		for _, tag := range a.Get("Tag.#.name").Array() {
+			name := tag.String()
+			attr.tags = append(attr.tags, name)
+			if level, ok := strings.CutPrefix(strings.ToLower(name), "tlp:"); ok && attr.tlp == "" {
+				attr.tlp = strings.ToUpper(level)
+			}
+			if cluster, ok := strings.CutPrefix(name, "misp-galaxy:"); ok {
+				attr.galaxies = append(attr.galaxies, cluster)
+			}
+		}
+		for _, galaxy := range a.Get("Galaxy").Array() {
+			for _, cluster := range galaxy.Get("GalaxyCluster.#.value").Array() {
+				attr.galaxies = append(attr.galaxies, galaxy.Get("name").String()+": "+cluster.String())
+			}
+		}
+
+		parts := strings.Split(a.Get("value").String(), "|")
+		for _, v := range values {
+			for _, part := range parts {
+				if strings.EqualFold(part, v) && len(matches[v]) < maxMISPMatches {
+					matches[v] = append(matches[v], attr)
+					break
+				}
+			}
+		}
+	}
+
+	results := make(map[string]lookupResult, len(values))
+	for _, v := range values {
+		results[v] = lookupResult{Value: matches[v], Found: len(matches[v]) > 0}
+	}
+	return results
+}
+
+// applyMISP appends an entry to threat.enrichments for every MISP
+// attribute matching the value of field. misp.category keeps the category
+// of the first attribute matched, as it was before threat.enrichments, for
+// the dashboards and rules that still read it.
+func applyMISP(doc *Document, field, value string, result lookupResult) {
+	attrs, _ := result.Value.([]mispAttribute)
+	if len(attrs) > 0 && !doc.Exists("misp.category") {
+		doc.Set("misp.category", attrs[0].category)
+	}
+	for _, attr := range attrs {
+		entry := &Document{root: newObject()}
+		entry.Set("matched.field", field)
+		entry.Set("matched.atomic", value)
+		entry.Set("matched.type", "indicator_match_rule")
+		entry.Set("indicator.provider", "misp")
+		entry.Set("indicator.type", attr.typ)
+		for _, f := range []struct{ field, value string }{
+			{"indicator.first_seen", attr.firstSeen},
+			{"indicator.last_seen", attr.lastSeen},
+			{"indicator.marking.tlp", attr.tlp},
+		} {
+			if f.value != "" {
+				entry.Set(f.field, f.value)
+			}
+		}
+		entry.Set("misp.event.id", attr.eventID)
+		entry.Set("misp.event.info", attr.eventInfo)
+		entry.Set("misp.attribute.id", attr.id)
+		entry.Set("misp.attribute.category", attr.category)
+		entry.Set("misp.attribute.to_ids", attr.toIDs)
+		entry.Set("misp.tags", append([]string{}, attr.tags...))
+		entry.Set("misp.galaxies", append([]string{}, attr.galaxies...))
+		doc.Set("threat.enrichments.-1", entry.root)
+	}
+}
@@ -2,6 +2,7 @@ package normalizer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sync/atomic"
@@ -18,115 +19,342 @@ import (
 // 	GroupID     string
 // }
 
-func Run(cfg *config.Config) error {
-	admin, err := kafka.NewAdminClient(&kafka.ConfigMap{"bootstrap.servers": cfg.Brokers})
-	if err == nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
-		topics := []kafka.TopicSpecification{
-			{Topic: cfg.InputTopic, NumPartitions: 1, ReplicationFactor: 1},
-			{Topic: cfg.OutputTopic, NumPartitions: 1, ReplicationFactor: 1},
+// Run normalizes events from the configured source to the configured sink
+// until ctx is cancelled. Kafka to Kafka, the default, consumes the input
+// topic and produces to the output topic with clients built by NewClients
+// and otherwise behaves as RunWithClients; any other combination runs
+// through RunPipeline.
+func Run(ctx context.Context, cfg *config.Config) error {
+	if cfg.Source != SourceKafka || cfg.Sink != SinkKafka {
+		source, err := NewSource(cfg)
+		if err != nil {
+			return err
 		}
-
-		if _, err := admin.CreateTopics(ctx, topics); err != nil {
-			log.Printf("Warning: Could not create topics: %v", err)
-		} else {
-			log.Printf("Topics Created: %s, %s", cfg.InputTopic, cfg.OutputTopic)
+		defer source.Close()
+		sink, err := NewSink(cfg)
+		if err != nil {
+			return err
 		}
-		admin.Close()
-	} else {
-		log.Printf("Client Unavailable for checking : %v", err)
+		defer sink.Close()
+		return RunPipeline(ctx, cfg, source, sink)
 	}
 
-	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
-		"bootstrap.servers":  cfg.Brokers,
-		"group.id":           cfg.GroupID,
-		"auto.offset.reset":  "earliest",
-		"enable.auto.commit": false,
-	})
+	clients, err := NewClients(cfg)
 	if err != nil {
 		return err
 	}
-	defer consumer.Close()
+	defer clients.Close()
+	return RunWithClients(ctx, cfg, clients)
+}
 
-	if err := consumer.SubscribeTopics([]string{cfg.InputTopic}, nil); err != nil {
-		return fmt.Errorf("failed to subscribe: %w", err)
+// RunWithClients is Run with the Kafka clients supplied by the caller. On
+// shutdown it stops reading, flushes the producer and commits the offsets
+// whose normalized output was acknowledged, all within cfg.ShutdownTimeout.
+// The clients are left open.
+//
+// When cfg.TransactionalID is set, output and offsets are committed together
+// in Kafka transactions instead (see runTransactional); the clients must then
+// have been created with the transactional settings NewClients uses.
+func RunWithClients(ctx context.Context, cfg *config.Config, clients *Clients) error {
+	if err := startRules(ctx, cfg); err != nil {
+		return err
 	}
 
-	producer, err := kafka.NewProducer(&kafka.ConfigMap{
-		"bootstrap.servers":  cfg.Brokers,
-		"enable.idempotence": true,
-		"compression.type":   "lz4",
-		"linger.ms":          10,
-		"batch.num.messages": 5000,
-	})
+	if clients.Admin != nil {
+		createTopics(ctx, cfg, clients.Admin)
+	}
 
-	if err != nil {
-		return fmt.Errorf("failed to create producer: %w", err)
+	if cfg.TransactionalID != "" {
+		return runTransactional(ctx, cfg, clients.Consumer, clients.Producer)
+	}
+	return runAtLeastOnce(ctx, cfg, clients.Consumer, clients.Producer)
+}
+
+// startRules configures the ruleset and enrichment and, for a rule file,
+// reloads it on changes until ctx is cancelled, as well as the threat-intel
+// feeds on their refresh interval.
+func startRules(ctx context.Context, cfg *config.Config) error {
+	if err := ConfigureRules(cfg); err != nil {
+		return err
+	}
+	if err := ConfigureEnrichment(cfg); err != nil {
+		return err
+	}
+	if cfg.RulesFile != "" {
+		go func() {
+			if err := watchRuleset(ctx, cfg.RulesFile); err != nil {
+				log.Printf("⚠️ Ruleset hot reload disabled: %v", err)
+			}
+		}()
+	}
+	if cfg.Enrich && cfg.EnrichFeeds != "" && cfg.EnrichFeedsRefresh > 0 {
+		go refreshFeeds(ctx, cfg.EnrichFeedsRefresh)
+	}
+	return nil
+}
+
+// runAtLeastOnce produces asynchronously and commits input offsets only once
+// the corresponding output has been acknowledged. An event that can be
+// neither delivered nor dead-lettered stops it with an error, so its offset
+// is read again on restart instead of holding back every later commit.
+func runAtLeastOnce(ctx context.Context, cfg *config.Config, consumer *kafka.Consumer, producer *kafka.Producer) error {
+	tracker := newOffsetTracker()
+	var pool *workerPool
+	// stopped is set once shutdown has committed the final offsets. The
+	// consumer may revoke its partitions later, when the caller closes it,
+	// and the producer may be closed by then.
+	var stopped atomic.Bool
+
+	rebalance := func(c *kafka.Consumer, ev kafka.Event) error {
+		switch e := ev.(type) {
+		case kafka.AssignedPartitions:
+			tracker.forget(e.Partitions)
+		case kafka.RevokedPartitions:
+			if stopped.Load() {
+				return nil
+			}
+			// Hand over the revoked partitions at the last delivered offset.
+			if pool != nil {
+				pool.drain()
+			}
+			producer.Flush(int(cfg.ShutdownTimeout.Milliseconds()))
+			tracker.wait(time.Now().Add(cfg.ShutdownTimeout))
+			commitDelivered(c, tracker)
+			tracker.forget(e.Partitions)
+		}
+		return nil
+	}
+	if err := consumer.SubscribeTopics([]string{cfg.InputTopic}, rebalance); err != nil {
+		return fmt.Errorf("failed to subscribe: %w", err)
 	}
-	defer producer.Close()
 
 	log.Printf("Normalizer starting...")
 
-	// Metrics
 	var msgCount uint64
+	// A lost event ends the run before ctx is cancelled.
+	metricsCtx, stopMetrics := context.WithCancel(ctx)
+	defer stopMetrics()
+	go reportMetrics(metricsCtx, &msgCount)
+
+	// --- Delivery report handler (async errors)
+	// Failed deliveries are dead-lettered by a goroutine of their own:
+	// producing may wait for room in the producer queue, which only the
+	// reader of producer.Events() frees up.
+	failed := make(chan failedDelivery, deadLetterQueueSize)
 	go func() {
-		ticker := time.NewTicker(5 * time.Second)
-		defer ticker.Stop()
-		var last uint64
-		for range ticker.C {
-			cur := atomic.LoadUint64(&msgCount)
-			rate := float64(cur-last) / 5.0
-			last = cur
-			log.Printf("[Metrics] %.2f msg/sec (total=%d)", rate, cur)
+		for f := range failed {
+			if produceDeadLetter(producer, cfg.DeadLetterTopic, f.d, StageDelivery, f.err) != nil {
+				tracker.delivered(f.d.src, f.err)
+			}
+			// Otherwise the dead letter's own report settles the input offset.
 		}
 	}()
-
-	// --- Delivery report handler (async errors)
 	go func() {
+		defer close(failed)
 		for e := range producer.Events() {
 			switch ev := e.(type) {
 			case *kafka.Message:
+				d, ok := ev.Opaque.(*delivery)
+				if !ok {
+					continue
+				}
 				if ev.TopicPartition.Error != nil {
 					log.Printf("❌ Delivery failed: %v", ev.TopicPartition.Error)
+					if !d.deadLetter && cfg.DeadLetterTopic != "" {
+						select {
+						case failed <- failedDelivery{d: d, err: ev.TopicPartition.Error}:
+							continue
+						default:
+							log.Printf("⚠️ Dead-letter queue full, not dead-lettering %v", d.src)
+						}
+					}
 				}
+				tracker.delivered(d.src, ev.TopicPartition.Error)
 			}
 		}
 	}()
 
-	const commitBatch = 500
-	for {
-		msg, err := consumer.ReadMessage(-1)
+	process := func(msg *kafka.Message) {
+		d := &delivery{src: msg.TopicPartition, value: msg.Value, key: msg.Key}
+
+		normalized, err := Normalize(string(msg.Value))
 		if err != nil {
-			log.Printf("Consumer Error: %v", err)
-			continue
+			log.Printf("⚠️ Normalization failed for %v: %v", msg.TopicPartition, err)
+			topic := deadLetterTopic(cfg, stageOf(err))
+			if err := produceDeadLetter(producer, topic, d, stageOf(err), err); err != nil {
+				if topic != "" {
+					// Never drop an event a dead-letter topic should have.
+					tracker.fail(msg.TopicPartition, err)
+					return
+				}
+				log.Printf("⚠️ Dropping event %v: %v", msg.TopicPartition, err)
+				tracker.ack(msg.TopicPartition)
+				return
+			}
+			tracker.produced()
+			return
 		}
 
-		normalized := ApplyRules(string(msg.Value))
-
-		err = producer.Produce(&kafka.Message{
+		err = produce(producer, &kafka.Message{
 			TopicPartition: kafka.TopicPartition{
 				Topic:     &cfg.OutputTopic,
 				Partition: kafka.PartitionAny,
 			},
-			Value: []byte(normalized),
-			Key:   msg.Key,
-		}, nil)
+			Value:  []byte(normalized),
+			Key:    msg.Key,
+			Opaque: d,
+		})
 
 		if err != nil {
 			log.Printf("Message Produce Error: %v", err)
+			if produceDeadLetter(producer, cfg.DeadLetterTopic, d, StageProduce, err) != nil {
+				tracker.fail(msg.TopicPartition, err)
+				return
+			}
+		}
+		tracker.produced()
+		atomic.AddUint64(&msgCount, 1)
+	}
+	pool = newWorkerPool(cfg.Workers, cfg.WorkerQueueSize, cfg.Ordering, process)
+	log.Printf("Normalizing with %d workers (ordered by %s)", len(pool.queues), cfg.Ordering)
+
+	const (
+		commitBatch    = 500
+		commitInterval = 5 * time.Second
+	)
+	var read uint64
+	lastCommit := time.Now()
+	// A lost event holds back its partition's commits for good; stop
+	// reading rather than queue offsets that can never be committed.
+	for ctx.Err() == nil && tracker.failure() == nil {
+		if time.Since(lastCommit) >= commitInterval {
+			commitDelivered(consumer, tracker)
+			lastCommit = time.Now()
+		}
+
+		msg, err := consumer.ReadMessage(100 * time.Millisecond)
+		if err != nil {
+			if kerr, ok := err.(kafka.Error); ok && kerr.IsTimeout() {
+				continue
+			}
+			log.Printf("Consumer Error: %v", err)
 			continue
 		}
 
-		atomic.AddUint64(&msgCount, 1)
+		// Register the offset in read order before a worker can settle it.
+		tracker.read(msg.TopicPartition)
+		pool.dispatch(msg)
+		read++
 
-		if msgCount%commitBatch == 0 {
-			if _, err := consumer.Commit(); err != nil {
-				log.Printf("⚠️ Commit failed: %v", err)
-			} else {
-				log.Printf("✅ Committed offsets after %d messages", msgCount)
+		if read%commitBatch == 0 {
+			if n := commitDelivered(consumer, tracker); n > 0 {
+				log.Printf("✅ Committed offsets after %d messages", read)
 			}
+			lastCommit = time.Now()
 		}
 	}
+
+	if err := tracker.failure(); err != nil {
+		log.Printf("❌ Stopping: %v", err)
+	}
+	pool.close()
+	defer stopped.Store(true)
+	if err := shutdown(consumer, producer, tracker, cfg.ShutdownTimeout); err != nil {
+		return err
+	}
+	if err := tracker.failure(); err != nil {
+		return fmt.Errorf("%w (offset not committed)", err)
+	}
+	return nil
+}
+
+// delivery is the Opaque of every message produced by runAtLeastOnce: the
+// input it settles and the original payload to dead-letter on failure.
+type delivery struct {
+	src        kafka.TopicPartition
+	value      []byte
+	key        []byte
+	deadLetter bool
+}
+
+// deadLetterQueueSize bounds the failed deliveries waiting to be
+// dead-lettered. Beyond it a failed delivery is lost and stops the
+// normalizer, as without a dead-letter topic.
+const deadLetterQueueSize = 1024
+
+// failedDelivery is a delivery report with an error, to be dead-lettered.
+type failedDelivery struct {
+	d   *delivery
+	err error
+}
+
+// produceDeadLetter sends the original payload of d to the dead-letter topic.
+// It fails when no dead-letter topic is configured.
+func produceDeadLetter(producer *kafka.Producer, topic string, d *delivery, stage Stage, cause error) error {
+	if topic == "" {
+		return errors.New("no dead-letter topic configured")
+	}
+	msg := deadLetter(topic, d.value, d.key, d.src, stage, cause)
+	msg.Opaque = &delivery{src: d.src, value: d.value, key: d.key, deadLetter: true}
+	if err := produce(producer, msg); err != nil {
+		log.Printf("❌ Dead-letter produce failed for %v: %v", d.src, err)
+		return err
+	}
+	return nil
+}
+
+// produce hands msg to the producer, waiting for room while the local
+// producer queue is full.
+func produce(producer *kafka.Producer, msg *kafka.Message) error {
+	for {
+		err := producer.Produce(msg, nil)
+		if kerr, ok := err.(kafka.Error); ok && kerr.Code() == kafka.ErrQueueFull {
+			producer.Flush(100)
+			continue
+		}
+		return err
+	}
+}
+
+// shutdown drains the producer, waits for the outstanding delivery reports
+// and commits the acknowledged offsets before the deferred Close calls run.
+func shutdown(consumer *kafka.Consumer, producer *kafka.Producer, tracker *offsetTracker, timeout time.Duration) error {
+	log.Printf("Shutdown requested, draining producer (timeout %s)", timeout)
+	deadline := time.Now().Add(timeout)
+
+	if remaining := producer.Flush(int(time.Until(deadline).Milliseconds())); remaining > 0 {
+		log.Printf("⚠️ %d messages still queued after flush", remaining)
+	}
+	if !tracker.wait(deadline) {
+		log.Printf("⚠️ Shutdown deadline reached before all delivery reports arrived")
+	}
+
+	offsets := tracker.commitOffsets()
+	if len(offsets) == 0 {
+		return nil
+	}
+	committed, err := consumer.CommitOffsets(offsets)
+	if err != nil {
+		return fmt.Errorf("final commit failed: %w", err)
+	}
+	tracker.committed(committed)
+	log.Printf("✅ Committed final offsets for %d partitions", len(offsets))
+	return nil
+}
+
+// commitDelivered commits, per partition, the offsets up to the highest
+// contiguous delivered message. It returns the number of partitions whose
+// position moved.
+func commitDelivered(consumer *kafka.Consumer, tracker *offsetTracker) int {
+	offsets := tracker.commitOffsets()
+	if len(offsets) == 0 {
+		return 0
+	}
+	committed, err := consumer.CommitOffsets(offsets)
+	if err != nil {
+		log.Printf("⚠️ Commit failed: %v", err)
+		return 0
+	}
+	tracker.committed(committed)
+	return len(offsets)
 }
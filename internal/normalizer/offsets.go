@@ -0,0 +1,199 @@
+package normalizer
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+// pendingOffset is one input message waiting for its delivery report.
+type pendingOffset struct {
+	offset kafka.Offset
+	done   bool
+}
+
+// partitionOffsets keeps the input offsets of one partition in read order so
+// the commit position only advances over a contiguous run of deliveries.
+type partitionOffsets struct {
+	queue     []*pendingOffset
+	byOffset  map[kafka.Offset]*pendingOffset
+	position  kafka.Offset
+	committed kafka.Offset
+}
+
+// offsetTracker records which input offsets have had their normalized output
+// acknowledged by the broker and computes, per partition, the highest offset
+// that is safe to commit.
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[partitionKey]*partitionOffsets
+	inflight   int64
+	// lost is the error of the first input message that was neither
+	// delivered nor dead-lettered.
+	lost error
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{partitions: map[partitionKey]*partitionOffsets{}}
+}
+
+func (t *offsetTracker) partition(tp kafka.TopicPartition) *partitionOffsets {
+	key := partitionKey{topic: *tp.Topic, partition: tp.Partition}
+	p, ok := t.partitions[key]
+	if !ok {
+		p = &partitionOffsets{
+			byOffset:  map[kafka.Offset]*pendingOffset{},
+			position:  kafka.OffsetInvalid,
+			committed: kafka.OffsetInvalid,
+		}
+		t.partitions[key] = p
+	}
+	return p
+}
+
+// read registers an input message before it is handed to the producer. Every
+// read offset blocks the commit position until it is acknowledged.
+func (t *offsetTracker) read(src kafka.TopicPartition) {
+	if src.Topic == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p := t.partition(src)
+	entry := &pendingOffset{offset: src.Offset}
+	p.queue = append(p.queue, entry)
+	p.byOffset[src.Offset] = entry
+}
+
+// produced registers a message handed to the producer.
+func (t *offsetTracker) produced() {
+	atomic.AddInt64(&t.inflight, 1)
+}
+
+// delivered records the delivery report for the input message at src, with
+// err the delivery error if it failed.
+func (t *offsetTracker) delivered(src kafka.TopicPartition, err error) {
+	defer atomic.AddInt64(&t.inflight, -1)
+	if err == nil {
+		t.ack(src)
+	} else {
+		t.fail(src, err)
+	}
+}
+
+// ack marks the input message at src as fully handled.
+func (t *offsetTracker) ack(src kafka.TopicPartition) {
+	if entry := t.entry(src); entry != nil {
+		t.mu.Lock()
+		entry.done = true
+		t.mu.Unlock()
+	}
+}
+
+// fail records the input message at src as lost. Its offset stays pending,
+// so neither it nor any later offset of its partition is committed again,
+// and failure reports the loss.
+func (t *offsetTracker) fail(src kafka.TopicPartition, cause error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.lost == nil {
+		t.lost = fmt.Errorf("event %v not delivered: %w", src, cause)
+	}
+}
+
+// failure returns the error of the first lost input message, or nil.
+func (t *offsetTracker) failure() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lost
+}
+
+func (t *offsetTracker) entry(src kafka.TopicPartition) *pendingOffset {
+	if src.Topic == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, exists := t.partitions[partitionKey{topic: *src.Topic, partition: src.Partition}]
+	if !exists {
+		return nil
+	}
+	return p.byOffset[src.Offset]
+}
+
+// wait blocks until every produced message has a delivery report or the
+// deadline passes. It reports whether all reports arrived.
+func (t *offsetTracker) wait(deadline time.Time) bool {
+	for atomic.LoadInt64(&t.inflight) > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
+
+// commitOffsets advances every partition over its leading run of
+// acknowledged offsets and returns the positions (one past the last
+// acknowledged offset) that have not been committed yet.
+func (t *offsetTracker) commitOffsets() []kafka.TopicPartition {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var offsets []kafka.TopicPartition
+	for key, p := range t.partitions {
+		for len(p.queue) > 0 && p.queue[0].done {
+			p.position = p.queue[0].offset + 1
+			delete(p.byOffset, p.queue[0].offset)
+			p.queue = p.queue[1:]
+		}
+		if p.position == kafka.OffsetInvalid || p.position == p.committed {
+			continue
+		}
+		topic := key.topic
+		offsets = append(offsets, kafka.TopicPartition{
+			Topic:     &topic,
+			Partition: key.partition,
+			Offset:    p.position,
+		})
+	}
+	return offsets
+}
+
+// committed records positions that the broker accepted.
+func (t *offsetTracker) committed(offsets []kafka.TopicPartition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, tp := range offsets {
+		if tp.Topic == nil || tp.Error != nil {
+			continue
+		}
+		if p, ok := t.partitions[partitionKey{topic: *tp.Topic, partition: tp.Partition}]; ok {
+			p.committed = tp.Offset
+		}
+	}
+}
+
+// forget drops the state of partitions that are no longer assigned to this
+// consumer.
+func (t *offsetTracker) forget(partitions []kafka.TopicPartition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, tp := range partitions {
+		if tp.Topic != nil {
+			delete(t.partitions, partitionKey{topic: *tp.Topic, partition: tp.Partition})
+		}
+	}
+}
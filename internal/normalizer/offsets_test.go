@@ -0,0 +1,137 @@
+package normalizer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+func offsetAt(partition int32, offset kafka.Offset) kafka.TopicPartition {
+	topic := "in"
+	return kafka.TopicPartition{Topic: &topic, Partition: partition, Offset: offset}
+}
+
+// positions returns the commit position per partition of offsets.
+func positions(offsets []kafka.TopicPartition) map[int32]kafka.Offset {
+	m := map[int32]kafka.Offset{}
+	for _, tp := range offsets {
+		m[tp.Partition] = tp.Offset
+	}
+	return m
+}
+
+func TestOffsetTrackerContiguous(t *testing.T) {
+	tr := newOffsetTracker()
+	for offset := range kafka.Offset(5) {
+		tr.read(offsetAt(0, offset))
+		tr.produced()
+	}
+	if got := tr.commitOffsets(); len(got) != 0 {
+		t.Fatalf("commit offsets %v before any delivery", got)
+	}
+
+	// Offsets 3 and 1 leave gaps: only the run up to 0 can be committed.
+	tr.delivered(offsetAt(0, 0), nil)
+	tr.delivered(offsetAt(0, 3), nil)
+	tr.delivered(offsetAt(0, 1), nil)
+	if got := positions(tr.commitOffsets()); got[0] != 2 {
+		t.Fatalf("position %v, want 2 with offset 2 pending", got)
+	}
+
+	// Filling the gap moves the position over the delivered offset 3.
+	tr.delivered(offsetAt(0, 2), nil)
+	if got := positions(tr.commitOffsets()); got[0] != 4 {
+		t.Fatalf("position %v, want 4 with offset 4 pending", got)
+	}
+	if tr.wait(time.Now()) {
+		t.Error("wait reported every delivery while offset 4 is in flight")
+	}
+	tr.delivered(offsetAt(0, 4), nil)
+	if !tr.wait(time.Now()) {
+		t.Error("wait did not see every delivery")
+	}
+	if got := positions(tr.commitOffsets()); got[0] != 5 {
+		t.Fatalf("position %v, want 5", got)
+	}
+	if err := tr.failure(); err != nil {
+		t.Errorf("failure %v without a lost event", err)
+	}
+}
+
+func TestOffsetTrackerCommitted(t *testing.T) {
+	tr := newOffsetTracker()
+	tr.read(offsetAt(0, 10))
+	tr.read(offsetAt(1, 20))
+	tr.ack(offsetAt(0, 10))
+	tr.ack(offsetAt(1, 20))
+
+	offsets := tr.commitOffsets()
+	if got := positions(offsets); got[0] != 11 || got[1] != 21 {
+		t.Fatalf("positions %v, want 11 and 21", got)
+	}
+	// The broker rejects partition 1; only partition 0 is up to date.
+	for i := range offsets {
+		if offsets[i].Partition == 1 {
+			offsets[i].Error = kafka.NewError(kafka.ErrUnknownPartition, "rejected", false)
+		}
+	}
+	tr.committed(offsets)
+	if got := positions(tr.commitOffsets()); len(got) != 1 || got[1] != 21 {
+		t.Errorf("positions %v after a partial commit, want only partition 1 at 21", got)
+	}
+}
+
+func TestOffsetTrackerFail(t *testing.T) {
+	tr := newOffsetTracker()
+	for offset := range kafka.Offset(3) {
+		tr.read(offsetAt(0, offset))
+		tr.produced()
+	}
+	tr.delivered(offsetAt(0, 0), nil)
+	tr.delivered(offsetAt(0, 1), errors.New("message too large"))
+	tr.delivered(offsetAt(0, 2), nil)
+
+	// The lost offset is never committed past, and is reported.
+	if got := positions(tr.commitOffsets()); got[0] != 1 {
+		t.Fatalf("position %v, want 1 before the lost offset", got)
+	}
+	err := tr.failure()
+	if err == nil || err.Error() != "event in[0]@1 not delivered: message too large" {
+		t.Fatalf("failure %v, want the loss of offset 1", err)
+	}
+	if !tr.wait(time.Now()) {
+		t.Error("a failed delivery is still in flight")
+	}
+
+	// Only the first loss is reported.
+	tr.read(offsetAt(1, 7))
+	tr.fail(offsetAt(1, 7), errors.New("no dead-letter topic"))
+	if got := tr.failure(); got != err {
+		t.Errorf("failure %v, want the first loss", got)
+	}
+}
+
+func TestOffsetTrackerForget(t *testing.T) {
+	tr := newOffsetTracker()
+	tr.read(offsetAt(0, 0))
+	tr.read(offsetAt(1, 0))
+	tr.ack(offsetAt(1, 0))
+
+	// A revoked partition is dropped with its pending offsets.
+	tr.forget([]kafka.TopicPartition{offsetAt(0, kafka.OffsetInvalid)})
+	tr.ack(offsetAt(0, 0))
+	offsets := tr.commitOffsets()
+	if got := positions(offsets); len(got) != 1 || got[1] != 1 {
+		t.Fatalf("positions %v, want only partition 1", got)
+	}
+	tr.committed(offsets)
+
+	// Reassigned, it starts over from the offsets read again.
+	tr.read(offsetAt(0, 0))
+	tr.ack(offsetAt(0, 0))
+	if got := positions(tr.commitOffsets()); len(got) != 1 || got[0] != 1 {
+		t.Errorf("positions %v, want partition 0 at 1", got)
+	}
+}
@@ -0,0 +1,246 @@
+package normalizer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/izzatbey/soc-norm-events/internal/config"
+)
+
+// Source and sink types selected by config.Config.Source and Sink.
+const (
+	SourceKafka  = "kafka"
+	SourceFile   = "file"
+	SourceStdin  = "stdin"
+	SourceHTTP   = "http"
+	SourceSyslog = "syslog"
+
+	SinkKafka  = "kafka"
+	SinkFile   = "file"
+	SinkStdout = "stdout"
+	SinkHTTP   = "http"
+)
+
+// Event is one raw event read from a Source.
+type Event struct {
+	Value []byte
+	Key   []byte
+
+	// Done, if set, is called once the event has been handled: when the sink
+	// has written the normalized event out, possibly from another goroutine
+	// well after handle returned, or when the event was dropped. ok is false
+	// when the normalized event could not be written to the sink, so a
+	// source that can redeliver should not consider the event consumed.
+	Done func(ok bool)
+}
+
+// Source reads raw events.
+type Source interface {
+	// Events calls handle for every event, one at a time, until ctx is
+	// cancelled or the source has no more events.
+	Events(ctx context.Context, handle func(Event)) error
+	Close() error
+}
+
+// Sink writes normalized events.
+type Sink interface {
+	// Write hands one event to the sink. It may buffer the event; Flush
+	// writes out what is buffered. done, if not nil, is called once the
+	// event has been written out, or with false if the sink gives up on it.
+	// When Write returns an error the event was not taken and done is not
+	// called.
+	Write(ctx context.Context, value, key []byte, done func(ok bool)) error
+	// Flush writes out what is buffered, giving up after timeout unless it
+	// is 0. Its error counts the events left unsent.
+	Flush(timeout time.Duration) error
+	Close() error
+}
+
+// NewSource creates the source selected by cfg.Source.
+func NewSource(cfg *config.Config) (Source, error) {
+	switch cfg.Source {
+	case SourceKafka:
+		return newKafkaSource(cfg)
+	case SourceFile:
+		if cfg.SourceFile == "" {
+			return nil, fmt.Errorf("file source needs SOURCE_FILE")
+		}
+		return newFileSource(cfg.SourceFile, cfg.SourceFollow)
+	case SourceStdin:
+		return newStdinSource(), nil
+	case SourceHTTP:
+		return newHTTPSource(cfg), nil
+	case SourceSyslog:
+		return newSyslogSource(cfg)
+	default:
+		return nil, fmt.Errorf("unknown source %q, expected %s, %s, %s, %s or %s",
+			cfg.Source, SourceKafka, SourceFile, SourceStdin, SourceHTTP, SourceSyslog)
+	}
+}
+
+// NewSink creates the sink selected by cfg.Sink.
+func NewSink(cfg *config.Config) (Sink, error) {
+	switch cfg.Sink {
+	case SinkKafka:
+		return newKafkaSink(cfg)
+	case SinkFile:
+		if cfg.SinkFile == "" {
+			return nil, fmt.Errorf("file sink needs SINK_FILE")
+		}
+		return newFileSink(cfg.SinkFile)
+	case SinkStdout:
+		return newStdoutSink(), nil
+	case SinkHTTP:
+		if cfg.SinkURL == "" {
+			return nil, fmt.Errorf("http sink needs SINK_URL")
+		}
+		return newHTTPSink(cfg.SinkURL), nil
+	default:
+		return nil, fmt.Errorf("unknown sink %q, expected %s, %s, %s or %s",
+			cfg.Sink, SinkKafka, SinkFile, SinkStdout, SinkHTTP)
+	}
+}
+
+// sinkRetryDelay is how long RunPipeline pauses after the sink refused an
+// event.
+const sinkRetryDelay = time.Second
+
+// RunPipeline normalizes the events of source into sink until ctx is
+// cancelled or the source is exhausted, then flushes the sink within
+// cfg.ShutdownTimeout. Events that fail to normalize go to the dead-letter
+// or quarantine topic for their stage, produced through the Kafka sink or a
+// producer of their own, and are logged and dropped when it is not set.
+func RunPipeline(ctx context.Context, cfg *config.Config, source Source, sink Sink) error {
+	if err := startRules(ctx, cfg); err != nil {
+		return err
+	}
+	log.Printf("Normalizer starting (source=%s, sink=%s)...", cfg.Source, cfg.Sink)
+
+	var dlq *kafkaSink
+	if cfg.DeadLetterTopic != "" || cfg.QuarantineTopic != "" {
+		if ks, ok := sink.(*kafkaSink); ok {
+			dlq = ks
+		} else {
+			var err error
+			if dlq, err = newKafkaSink(cfg); err != nil {
+				return fmt.Errorf("dead-letter producer: %w", err)
+			}
+			defer dlq.Close()
+		}
+	}
+
+	var msgCount uint64
+	go reportMetrics(ctx, &msgCount)
+
+	// refused gives up on an event the pipeline could not pass on, and gives
+	// the sink or the dead-letter topic time to recover before it comes again.
+	refused := func(ev Event) {
+		if ev.Done != nil {
+			ev.Done(false)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(sinkRetryDelay):
+		}
+	}
+
+	err := source.Events(ctx, func(ev Event) {
+		done := func(ok bool) {
+			if ok {
+				atomic.AddUint64(&msgCount, 1)
+			}
+			if ev.Done != nil {
+				ev.Done(ok)
+			}
+		}
+		normalized, err := Normalize(string(ev.Value))
+		if err != nil {
+			stage := stageOf(err)
+			topic := deadLetterTopic(cfg, stage)
+			if topic == "" {
+				log.Printf("⚠️ Dropping event: %v", err)
+				if ev.Done != nil {
+					ev.Done(true)
+				}
+				return
+			}
+			log.Printf("⚠️ Normalization failed, dead-lettering to %s: %v", topic, err)
+			if err := dlq.deadLetter(topic, ev.Value, ev.Key, stage, err, ev.Done); err != nil {
+				log.Printf("❌ Dead-letter produce failed: %v", err)
+				refused(ev)
+			}
+			return
+		}
+		if err := sink.Write(ctx, []byte(normalized), ev.Key, done); err != nil {
+			log.Printf("❌ Sink write failed: %v", err)
+			refused(ev)
+		}
+	})
+
+	log.Printf("Source stopped, flushing sink (timeout %s)", cfg.ShutdownTimeout)
+	if ferr := sink.Flush(cfg.ShutdownTimeout); ferr != nil {
+		log.Printf("⚠️ Sink flush failed: %v", ferr)
+		if err == nil {
+			err = ferr
+		}
+	}
+	if dlq != nil && dlq != sink {
+		if ferr := dlq.Flush(cfg.ShutdownTimeout); ferr != nil {
+			log.Printf("⚠️ Dead-letter flush failed: %v", ferr)
+			if err == nil {
+				err = ferr
+			}
+		}
+	}
+	return err
+}
+
+// ReadEvents calls handle for every event of r: the elements of a top-level
+// JSON array, or each value of a stream of JSON values such as NDJSON.
+func ReadEvents(r io.Reader, handle func(raw []byte) error) error {
+	br := bufio.NewReader(r)
+	var array bool
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !unicode.IsSpace(rune(b)) {
+			array = b == '['
+			br.UnreadByte()
+			break
+		}
+	}
+
+	dec := json.NewDecoder(br)
+	if array {
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+	}
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		if err := handle(raw); err != nil {
+			return err
+		}
+	}
+	if array {
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
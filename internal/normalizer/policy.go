@@ -0,0 +1,67 @@
+package normalizer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// FailurePolicy decides what happens to an event when a rule panics on it.
+type FailurePolicy string
+
+const (
+	// FailContinue drops the failing rule's changes and keeps normalizing.
+	FailContinue FailurePolicy = "continue"
+	// FailQuarantine stops normalizing and routes the original event to the
+	// quarantine (or dead-letter) topic.
+	FailQuarantine FailurePolicy = "quarantine"
+)
+
+var (
+	policyMu       sync.RWMutex
+	defaultPolicy  = FailContinue
+	policyOverride = map[string]FailurePolicy{}
+)
+
+// SetFailurePolicies configures the per-rule failure policies from a spec
+// such as "continue,sysmonWinRemap=quarantine": a bare policy sets the
+// default, rule=policy overrides it for one rule.
+func SetFailurePolicies(spec string) error {
+	def := FailContinue
+	overrides := map[string]FailurePolicy{}
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, hasName := strings.Cut(part, "=")
+		if !hasName {
+			name, value = "", name
+		}
+		policy := FailurePolicy(strings.ToLower(strings.TrimSpace(value)))
+		if policy != FailContinue && policy != FailQuarantine {
+			return fmt.Errorf("unknown rule failure policy %q", value)
+		}
+		if name = strings.TrimSpace(name); name == "" {
+			def = policy
+		} else {
+			overrides[name] = policy
+		}
+	}
+
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	defaultPolicy = def
+	policyOverride = overrides
+	return nil
+}
+
+func failurePolicy(ruleName string) FailurePolicy {
+	policyMu.RLock()
+	defer policyMu.RUnlock()
+	if p, ok := policyOverride[ruleName]; ok {
+		return p
+	}
+	return defaultPolicy
+}
@@ -0,0 +1,101 @@
+package normalizer
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSetFailurePolicies(t *testing.T) {
+	t.Cleanup(func() { SetFailurePolicies("") })
+
+	for name, tc := range map[string]struct {
+		spec  string
+		want  map[string]FailurePolicy
+		error bool
+	}{
+		"empty":            {spec: "", want: map[string]FailurePolicy{"any": FailContinue}},
+		"default":          {spec: "quarantine", want: map[string]FailurePolicy{"any": FailQuarantine}},
+		"override":         {spec: "continue, sysmonWinRemap = Quarantine", want: map[string]FailurePolicy{"any": FailContinue, "sysmonWinRemap": FailQuarantine}},
+		"override first":   {spec: "a=continue,quarantine", want: map[string]FailurePolicy{"a": FailContinue, "b": FailQuarantine}},
+		"empty parts":      {spec: ",,a=quarantine,", want: map[string]FailurePolicy{"a": FailQuarantine, "b": FailContinue}},
+		"unknown":          {spec: "drop", error: true},
+		"unknown override": {spec: "a=drop", error: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			SetFailurePolicies("a=quarantine")
+			err := SetFailurePolicies(tc.spec)
+			if tc.error {
+				if err == nil {
+					t.Fatal("no error")
+				}
+				// A bad spec keeps the policies in force.
+				if got := failurePolicy("a"); got != FailQuarantine {
+					t.Errorf("policy of a after a bad spec = %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for rule, want := range tc.want {
+				if got := failurePolicy(rule); got != want {
+					t.Errorf("policy of %s = %s, want %s", rule, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunRulesPanic(t *testing.T) {
+	t.Cleanup(func() { SetFailurePolicies("") })
+
+	rules := []rule{
+		{name: "panicky", apply: func(doc *Document) {
+			doc.Set("data.partial", "edit")
+			doc.Set("data.user", "changed")
+			var m map[string]int
+			m["boom"]++
+		}},
+		{name: "after", apply: func(doc *Document) { doc.Set("data.after", true) }},
+	}
+	for name, tc := range map[string]struct {
+		spec        string
+		quarantined bool
+	}{
+		"continue":   {spec: "continue"},
+		"quarantine": {spec: "continue,panicky=quarantine", quarantined: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := SetFailurePolicies(tc.spec); err != nil {
+				t.Fatal(err)
+			}
+			before := RuleFailureCounts()["panicky"]
+			doc, _ := ParseDocument(`{"id":"1.2","agent":{"name":"web-01"},"data":{"user":"alice"}}`)
+
+			err := runRules(doc, rules, nil)
+
+			if got := RuleFailureCounts()["panicky"]; got != before+1 {
+				t.Errorf("failure count %d, want %d", got, before+1)
+			}
+			if doc.Exists("data.partial") || doc.GetString("data.user") != "alice" {
+				t.Errorf("partial edits not rolled back: %s", doc)
+			}
+			if tc.quarantined {
+				var ruleErr *RuleError
+				if !errors.As(err, &ruleErr) || ruleErr.Rule != "panicky" || ruleErr.EventID != "1.2" || ruleErr.Agent != "web-01" {
+					t.Fatalf("error %v, want the panic of panicky", err)
+				}
+				if doc.Exists("normalizer.failed_rules") || doc.Exists("data.after") {
+					t.Errorf("quarantined event kept normalizing: %s", doc)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if doc.GetString("normalizer.failed_rules") != `["panicky"]` || doc.GetString("data.after") != "true" {
+				t.Errorf("event did not continue past the failed rule: %s", doc)
+			}
+		})
+	}
+}
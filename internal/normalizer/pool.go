@@ -0,0 +1,89 @@
+package normalizer
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// Ordering modes for the worker pool.
+const (
+	OrderByPartition = "partition"
+	OrderByKey       = "key"
+)
+
+// workerPool normalizes messages on several goroutines. Every message is
+// routed to a worker by its input partition (or message key), so messages
+// sharing a partition (or key) are processed in the order they were read.
+type workerPool struct {
+	queues  []chan *kafka.Message
+	byKey   bool
+	pending sync.WaitGroup
+	exited  sync.WaitGroup
+}
+
+// newWorkerPool starts workers goroutines, each with a queue of queueSize
+// messages, that call process for every dispatched message.
+func newWorkerPool(workers, queueSize int, ordering string, process func(*kafka.Message)) *workerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+
+	p := &workerPool{
+		queues: make([]chan *kafka.Message, workers),
+		byKey:  ordering == OrderByKey,
+	}
+	for i := range p.queues {
+		queue := make(chan *kafka.Message, queueSize)
+		p.queues[i] = queue
+		p.exited.Add(1)
+		go func() {
+			defer p.exited.Done()
+			for msg := range queue {
+				process(msg)
+				p.pending.Done()
+			}
+		}()
+	}
+	return p
+}
+
+// dispatch queues msg on its worker, blocking while that queue is full.
+func (p *workerPool) dispatch(msg *kafka.Message) {
+	p.pending.Add(1)
+	p.queues[p.shard(msg)] <- msg
+}
+
+func (p *workerPool) shard(msg *kafka.Message) int {
+	if len(p.queues) == 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	if p.byKey && len(msg.Key) > 0 {
+		h.Write(msg.Key)
+	} else {
+		if msg.TopicPartition.Topic != nil {
+			h.Write([]byte(*msg.TopicPartition.Topic))
+		}
+		partition := msg.TopicPartition.Partition
+		h.Write([]byte{byte(partition >> 24), byte(partition >> 16), byte(partition >> 8), byte(partition)})
+	}
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+// drain waits until every dispatched message has been processed.
+func (p *workerPool) drain() {
+	p.pending.Wait()
+}
+
+// close processes the remaining queued messages and stops the workers.
+func (p *workerPool) close() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.exited.Wait()
+}
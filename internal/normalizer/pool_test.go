@@ -0,0 +1,146 @@
+package normalizer
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// poolMessage returns a message of topic "in" whose value is its sequence
+// number within its partition or key.
+func poolMessage(partition int32, key string, seq int) *kafka.Message {
+	topic := "in"
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition},
+		Value:          []byte(fmt.Sprint(seq)),
+	}
+	if key != "" {
+		msg.Key = []byte(key)
+	}
+	return msg
+}
+
+func TestWorkerPoolOrdering(t *testing.T) {
+	for _, ordering := range []string{OrderByPartition, OrderByKey} {
+		t.Run(ordering, func(t *testing.T) {
+			var mu sync.Mutex
+			seen := map[string][]string{}
+			pool := newWorkerPool(4, 2, ordering, func(msg *kafka.Message) {
+				shard := fmt.Sprint(msg.TopicPartition.Partition)
+				if ordering == OrderByKey {
+					shard = string(msg.Key)
+				}
+				// Uneven work so that unordered shards would interleave.
+				time.Sleep(time.Duration(len(msg.Value)%3) * time.Millisecond)
+				mu.Lock()
+				seen[shard] = append(seen[shard], string(msg.Value))
+				mu.Unlock()
+			})
+
+			const perShard = 50
+			for seq := range perShard {
+				for shard := range 8 {
+					if ordering == OrderByKey {
+						// The keys share one partition, so only the key keeps them apart.
+						pool.dispatch(poolMessage(0, fmt.Sprint("key-", shard), seq))
+					} else {
+						pool.dispatch(poolMessage(int32(shard), "", seq))
+					}
+				}
+			}
+			pool.close()
+
+			if len(seen) != 8 {
+				t.Fatalf("%d shards processed, want 8", len(seen))
+			}
+			for shard, values := range seen {
+				if len(values) != perShard {
+					t.Errorf("shard %s: %d messages processed, want %d", shard, len(values), perShard)
+				}
+				for i, v := range values {
+					if v != fmt.Sprint(i) {
+						t.Errorf("shard %s: message %s processed at position %d", shard, v, i)
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWorkerPoolShard(t *testing.T) {
+	pool := newWorkerPool(16, 1, OrderByKey, func(*kafka.Message) {})
+	defer pool.close()
+
+	// Keyless messages fall back to their partition.
+	if pool.shard(poolMessage(3, "", 0)) != pool.shard(poolMessage(3, "", 1)) {
+		t.Error("keyless messages of one partition on different workers")
+	}
+	// A key picks the same worker on any partition.
+	if pool.shard(poolMessage(1, "host-a", 0)) != pool.shard(poolMessage(2, "host-a", 1)) {
+		t.Error("messages with one key on different workers")
+	}
+}
+
+func TestWorkerPoolDrain(t *testing.T) {
+	release := make(chan struct{})
+	var processed atomic.Int32
+	pool := newWorkerPool(2, 10, OrderByPartition, func(*kafka.Message) {
+		<-release
+		processed.Add(1)
+	})
+	defer pool.close()
+
+	for seq := range 6 {
+		pool.dispatch(poolMessage(int32(seq%3), "", seq))
+	}
+
+	// A revoke drains the pool before the partitions are given up: drain
+	// only returns once every dispatched message was processed.
+	drained := make(chan struct{})
+	go func() {
+		pool.drain()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		t.Fatal("drain returned with messages in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain did not return")
+	}
+	if got := processed.Load(); got != 6 {
+		t.Errorf("%d messages processed when drain returned, want 6", got)
+	}
+
+	// The pool keeps working after a drain.
+	pool.dispatch(poolMessage(0, "", 6))
+	pool.drain()
+	if got := processed.Load(); got != 7 {
+		t.Errorf("%d messages processed after a second drain, want 7", got)
+	}
+}
+
+func TestWorkerPoolClose(t *testing.T) {
+	var processed atomic.Int32
+	pool := newWorkerPool(1, 100, OrderByPartition, func(*kafka.Message) {
+		time.Sleep(time.Millisecond)
+		processed.Add(1)
+	})
+	for seq := range 20 {
+		pool.dispatch(poolMessage(0, "", seq))
+	}
+	// close processes what is still queued before the workers stop.
+	pool.close()
+	if got := processed.Load(); got != 20 {
+		t.Errorf("%d messages processed by close, want 20", got)
+	}
+}
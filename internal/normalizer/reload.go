@@ -0,0 +1,132 @@
+package normalizer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay lets an editor or deployment finish writing before the
+// changed files are read.
+const reloadDelay = 500 * time.Millisecond
+
+// Ruleset reload counters, reported by reportMetrics.
+var (
+	rulesetReloads        uint64
+	rulesetReloadFailures uint64
+)
+
+// watchRuleset reloads the ruleset at path whenever the rule file or one of
+// its lookup files changes, until ctx is cancelled. A new ruleset replaces
+// the active one only if it compiles; otherwise the active ruleset stays in
+// place and the error is logged. Events being normalized during a swap
+// finish with the ruleset they started with.
+func watchRuleset(ctx context.Context, path string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("watch ruleset: %w", err)
+	}
+	defer watcher.Close()
+
+	// Directories are watched rather than files so that files replaced by
+	// a rename (editors) keep being followed. A ConfigMap volume never
+	// touches the files themselves: it swaps the ..data symlink they point
+	// through, so every event in a watched directory also checks whether a
+	// file now resolves to a different target.
+	files := map[string]string{} // file -> resolved target
+	dirs := map[string]bool{}
+	addDir := func(dir string) {
+		if dirs[dir] {
+			return
+		}
+		if err := watcher.Add(dir); err != nil {
+			log.Printf("⚠️ Cannot watch %s for ruleset changes: %v", dir, err)
+			return
+		}
+		dirs[dir] = true
+	}
+	watch := func(rs *Ruleset) {
+		clear(files)
+		for _, f := range append([]string{path}, rs.files...) {
+			abs, err := filepath.Abs(f)
+			if err != nil {
+				continue
+			}
+			files[abs] = resolve(abs)
+			addDir(filepath.Dir(abs))
+			addDir(filepath.Dir(files[abs]))
+		}
+	}
+	changed := func(name string) bool {
+		abs, err := filepath.Abs(name)
+		if err != nil {
+			return false
+		}
+		for f, target := range files {
+			if abs == f || abs == target || resolve(f) != target {
+				return true
+			}
+		}
+		return false
+	}
+	watch(ActiveRuleset())
+	log.Printf("👀 Watching %s for ruleset changes", path)
+
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !ev.Has(fsnotify.Chmod) && changed(ev.Name) {
+				reload = time.After(reloadDelay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("⚠️ Ruleset watcher error: %v", err)
+		case <-reload:
+			reload = nil
+			if rs, ok := reloadRuleset(path); ok {
+				watch(rs)
+			}
+		}
+	}
+}
+
+// resolve returns path with its symlinks evaluated, or path itself when
+// that fails, e.g. while a symlink is being replaced.
+func resolve(path string) string {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		return target
+	}
+	return path
+}
+
+// reloadRuleset compiles the ruleset at path and makes it active. It
+// reports false, keeping the active ruleset, if compilation fails.
+func reloadRuleset(path string) (*Ruleset, bool) {
+	current := ActiveRuleset()
+	rs, err := LoadRuleset(path)
+	if err != nil {
+		atomic.AddUint64(&rulesetReloadFailures, 1)
+		log.Printf("❌ Ruleset reload failed, keeping %s: %v", current, err)
+		return nil, false
+	}
+	if rs.Hash == current.Hash {
+		return rs, true
+	}
+	UseRuleset(rs)
+	atomic.AddUint64(&rulesetReloads, 1)
+	log.Printf("📜 Ruleset reloaded: %s (was %s)", rs, current)
+	return rs, true
+}
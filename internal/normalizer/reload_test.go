@@ -0,0 +1,140 @@
+package normalizer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rulesVersion returns the default ruleset with its version set to version.
+func rulesVersion(version string) []byte {
+	return bytes.Replace(defaultRules, []byte(`version: "1"`), []byte(`version: "`+version+`"`), 1)
+}
+
+// useDefaultRuleset restores the default ruleset after the test.
+func useDefaultRuleset(t *testing.T) {
+	t.Cleanup(func() {
+		rs, err := LoadRuleset("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		UseRuleset(rs)
+	})
+}
+
+// waitForVersion waits until the active ruleset has version.
+func waitForVersion(t *testing.T, version string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for ActiveRuleset().Version != version {
+		if time.Now().After(deadline) {
+			t.Fatalf("active ruleset version %q, want %q", ActiveRuleset().Version, version)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestReloadRuleset(t *testing.T) {
+	useDefaultRuleset(t)
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(path, rulesVersion("1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rs, err := LoadRuleset(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	UseRuleset(rs)
+	hash := func() string {
+		out, err := Normalize(`{"decoder":{"name":"fortigate"},"data":{"srcip":"10.0.0.1"}}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc, _ := ParseDocument(out)
+		return doc.GetString("normalizer.ruleset.hash")
+	}
+	if got := hash(); got != rs.Hash {
+		t.Fatalf("normalizer.ruleset.hash = %q, want %q", got, rs.Hash)
+	}
+
+	// A ruleset that does not compile keeps the active one.
+	failures := atomic.LoadUint64(&rulesetReloadFailures)
+	os.WriteFile(path, bytes.Replace(rulesVersion("2"), []byte("before: [hostnameRemap]"), []byte("before: [noSuchRule]"), 1), 0o644)
+	if _, ok := reloadRuleset(path); ok {
+		t.Fatal("broken ruleset was reloaded")
+	}
+	if ActiveRuleset() != rs || hash() != rs.Hash {
+		t.Errorf("active ruleset replaced by a broken one: %s", ActiveRuleset())
+	}
+	if got := atomic.LoadUint64(&rulesetReloadFailures); got != failures+1 {
+		t.Errorf("reload failures %d, want %d", got, failures+1)
+	}
+
+	// A valid edit replaces it.
+	os.WriteFile(path, rulesVersion("3"), 0o644)
+	reloaded, ok := reloadRuleset(path)
+	if !ok || ActiveRuleset() != reloaded || reloaded.Version != "3" {
+		t.Fatalf("valid ruleset not reloaded: %v, %t", reloaded, ok)
+	}
+	if got := hash(); got != reloaded.Hash || got == rs.Hash {
+		t.Errorf("normalizer.ruleset.hash = %q after the reload, want %q", got, reloaded.Hash)
+	}
+
+	// An unchanged file keeps the active ruleset.
+	if again, ok := reloadRuleset(path); !ok || again.Hash != reloaded.Hash || ActiveRuleset() != reloaded {
+		t.Errorf("reload of an unchanged ruleset replaced it")
+	}
+}
+
+func TestWatchRulesetConfigMap(t *testing.T) {
+	useDefaultRuleset(t)
+
+	// The layout of a ConfigMap volume: rules.yaml -> ..data/rules.yaml,
+	// with ..data a symlink to the directory of the current version.
+	dir := t.TempDir()
+	writeVersion := func(version string) {
+		t.Helper()
+		if err := os.Mkdir(filepath.Join(dir, "..v"+version), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "..v"+version, "rules.yaml"), rulesVersion(version), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("..v"+version, filepath.Join(dir, "..data_tmp")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeVersion("1")
+	path := filepath.Join(dir, "rules.yaml")
+	if err := os.Symlink(filepath.Join("..data", "rules.yaml"), path); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := LoadRuleset(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	UseRuleset(rs)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- watchRuleset(ctx, path) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(100 * time.Millisecond) // let the watcher start
+
+	writeVersion("2")
+	waitForVersion(t, "2")
+	writeVersion("3")
+	waitForVersion(t, "3")
+}
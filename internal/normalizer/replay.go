@@ -0,0 +1,201 @@
+package normalizer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/izzatbey/soc-norm-events/internal/config"
+)
+
+// ReplayOptions controls ReplayDeadLetters.
+type ReplayOptions struct {
+	// Topic is the topic to replay, cfg.DeadLetterTopic if empty. Set it to
+	// cfg.QuarantineTopic to replay quarantined events.
+	Topic string
+	// GroupID is the consumer group that tracks replay progress on the
+	// topic.
+	GroupID string
+	// Stages limits the replay to events that failed at these stages. Other
+	// events are skipped; empty replays everything.
+	Stages []Stage
+	// Limit stops the replay after this many dead letters (0 = no limit).
+	Limit int
+	// DryRun normalizes and writes the result to Output instead of producing
+	// it, and does not commit replay progress.
+	DryRun bool
+	Output io.Writer
+}
+
+// ReplayStats summarizes a replay run.
+type ReplayStats struct {
+	Read     int
+	Replayed int
+	Skipped  int
+	Failed   int
+}
+
+// ReplayDeadLetters reads the dead-letter topic (or opts.Topic) up to its
+// current end, runs every original payload through the rules again and
+// produces the events that now normalize cleanly to the output topic. Events that still fail are
+// logged and left behind. Replay progress is committed for GroupID up to the
+// first dead letter that was skipped, still fails or was not delivered, so a
+// later replay reads that one again.
+func ReplayDeadLetters(ctx context.Context, cfg *config.Config, opts ReplayOptions) (ReplayStats, error) {
+	var stats ReplayStats
+	topic := opts.Topic
+	if topic == "" {
+		topic = cfg.DeadLetterTopic
+	}
+	if topic == "" {
+		return stats, fmt.Errorf("no dead-letter topic configured (KAFKA_DLQ_TOPIC)")
+	}
+	if err := ConfigureRules(cfg); err != nil {
+		return stats, err
+	}
+	if err := ConfigureEnrichment(cfg); err != nil {
+		return stats, err
+	}
+
+	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":    cfg.Brokers,
+		"group.id":             opts.GroupID,
+		"auto.offset.reset":    "earliest",
+		"enable.auto.commit":   false,
+		"enable.partition.eof": true,
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	producer, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers":  cfg.Brokers,
+		"enable.idempotence": true,
+	})
+	if err != nil {
+		consumer.Close()
+		return stats, fmt.Errorf("failed to create producer: %w", err)
+	}
+	// The consumer goes first, as in Clients.Close.
+	defer func() {
+		consumer.Close()
+		producer.Close()
+	}()
+
+	// Only dead letters whose replayed event was delivered are committed.
+	tracker := newOffsetTracker()
+	go func() {
+		for e := range producer.Events() {
+			if ev, ok := e.(*kafka.Message); ok {
+				if ev.TopicPartition.Error != nil {
+					log.Printf("❌ Delivery failed: %v", ev.TopicPartition.Error)
+				}
+				if src, ok := ev.Opaque.(kafka.TopicPartition); ok {
+					tracker.delivered(src, ev.TopicPartition.Error)
+				}
+			}
+		}
+	}()
+
+	if err := consumer.Subscribe(topic, nil); err != nil {
+		return stats, fmt.Errorf("failed to subscribe: %w", err)
+	}
+
+	wanted := map[Stage]bool{}
+	for _, s := range opts.Stages {
+		wanted[s] = true
+	}
+
+	const idleTimeout = 30 * time.Second
+	atEOF := map[int32]bool{}
+	lastActivity := time.Now()
+
+	for ctx.Err() == nil {
+		if opts.Limit > 0 && stats.Read >= opts.Limit {
+			break
+		}
+		if time.Since(lastActivity) > idleTimeout {
+			log.Printf("No dead letters received for %s, stopping", idleTimeout)
+			break
+		}
+
+		switch ev := consumer.Poll(100).(type) {
+		case kafka.PartitionEOF:
+			atEOF[ev.Partition] = true
+			if assigned, err := consumer.Assignment(); err == nil && len(assigned) > 0 && len(atEOF) >= len(assigned) {
+				return stats, finishReplay(consumer, producer, tracker, opts, cfg.ShutdownTimeout)
+			}
+		case kafka.Error:
+			log.Printf("Consumer Error: %v", ev)
+		case *kafka.Message:
+			lastActivity = time.Now()
+			delete(atEOF, ev.TopicPartition.Partition)
+			stats.Read++
+			tracker.read(ev.TopicPartition)
+
+			stage := Stage(headerValue(ev.Headers, HeaderStage))
+			if len(wanted) > 0 && !wanted[stage] {
+				stats.Skipped++
+				continue
+			}
+
+			normalized, err := Normalize(string(ev.Value))
+			if err != nil {
+				stats.Failed++
+				log.Printf("⚠️ Dead letter %v (%s, originally %s[%s]@%s) still fails: %v",
+					ev.TopicPartition, stage, headerValue(ev.Headers, HeaderSourceTopic),
+					headerValue(ev.Headers, HeaderSourcePartition), headerValue(ev.Headers, HeaderSourceOffset), err)
+				continue
+			}
+
+			if opts.DryRun {
+				fmt.Fprintln(opts.Output, normalized)
+				stats.Replayed++
+				continue
+			}
+
+			err = produce(producer, &kafka.Message{
+				TopicPartition: kafka.TopicPartition{Topic: &cfg.OutputTopic, Partition: kafka.PartitionAny},
+				Value:          []byte(normalized),
+				Key:            ev.Key,
+				Opaque:         ev.TopicPartition,
+			})
+			if err != nil {
+				stats.Failed++
+				log.Printf("❌ Produce replayed event %v: %v", ev.TopicPartition, err)
+				continue
+			}
+			tracker.produced()
+			stats.Replayed++
+		}
+	}
+
+	return stats, finishReplay(consumer, producer, tracker, opts, cfg.ShutdownTimeout)
+}
+
+// finishReplay flushes the replayed events and commits the replay position
+// over the dead letters whose replayed event was delivered.
+func finishReplay(consumer *kafka.Consumer, producer *kafka.Producer, tracker *offsetTracker, opts ReplayOptions, timeout time.Duration) error {
+	if opts.DryRun {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	producer.Flush(int(timeout.Milliseconds()))
+	var err error
+	if !tracker.wait(deadline) {
+		err = fmt.Errorf("%d replayed events not delivered within %s", atomic.LoadInt64(&tracker.inflight), timeout)
+	} else if lost := tracker.failure(); lost != nil {
+		err = fmt.Errorf("replay position held before a failed delivery: %w", lost)
+	}
+
+	if offsets := tracker.commitOffsets(); len(offsets) > 0 {
+		if _, cerr := consumer.CommitOffsets(offsets); cerr != nil {
+			return fmt.Errorf("commit replay position: %w", cerr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,760 @@
+package normalizer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// defaultRules is the ruleset used unless RULES_FILE names another one.
+//
+//go:embed rules/default.yaml
+var defaultRules []byte
+
+// ruleFile is the YAML format of a ruleset; see rules/default.yaml.
+type ruleFile struct {
+	Version     string              `yaml:"version"`
+	Lookups     map[string]pairList `yaml:"lookups"`
+	LookupFiles map[string]string   `yaml:"lookup_files"`
+	Categories  []categorySpec      `yaml:"categories"`
+	Before      []string            `yaml:"before"`
+	Pipelines   map[string][]string `yaml:"pipelines"`
+	After       []string            `yaml:"after"`
+	Rules       []ruleSpec          `yaml:"rules"`
+}
+
+type categorySpec struct {
+	Name string          `yaml:"name"`
+	When []conditionSpec `yaml:"when"`
+}
+
+type ruleSpec struct {
+	Name  string          `yaml:"name"`
+	When  []conditionSpec `yaml:"when"`
+	Steps []stepSpec      `yaml:"steps"`
+}
+
+type conditionSpec struct {
+	Field      string          `yaml:"field"`
+	Equals     *string         `yaml:"equals"`
+	Contains   stringList      `yaml:"contains"`
+	Regex      *string         `yaml:"regex"`
+	Exists     *bool           `yaml:"exists"`
+	In         []string        `yaml:"in"`
+	Gte        *int64          `yaml:"gte"`
+	Lte        *int64          `yaml:"lte"`
+	IgnoreCase bool            `yaml:"ignore_case"`
+	Not        bool            `yaml:"not"`
+	Any        []conditionSpec `yaml:"any"`
+	All        []conditionSpec `yaml:"all"`
+}
+
+type stepSpec struct {
+	When []conditionSpec `yaml:"when"`
+
+	Rename    pairList          `yaml:"rename"`
+	Copy      pairList          `yaml:"copy"`
+	Set       *setSpec          `yaml:"set"`
+	Delete    stringList        `yaml:"delete"`
+	Lowercase stringList        `yaml:"lowercase"`
+	Split     *splitSpec        `yaml:"split"`
+	Lookup    *lookupSpec       `yaml:"lookup"`
+	First     []stepSpec        `yaml:"first"`
+	Call      string            `yaml:"call"`
+	With      map[string]string `yaml:"with"`
+}
+
+type setSpec struct {
+	Field string    `yaml:"field"`
+	Value yaml.Node `yaml:"value"`
+}
+
+type splitSpec struct {
+	Field     string `yaml:"field"`
+	Separator string `yaml:"separator"`
+	To        string `yaml:"to"`
+}
+
+type lookupSpec struct {
+	Field      string     `yaml:"field"`
+	Fields     []string   `yaml:"fields"`
+	Table      string     `yaml:"table"`
+	Entries    pairList   `yaml:"entries"`
+	Match      string     `yaml:"match"`
+	IgnoreCase bool       `yaml:"ignore_case"`
+	To         string     `yaml:"to"`
+	Then       []stepSpec `yaml:"then"`
+}
+
+// pair is one "key: value" entry of an ordered YAML mapping.
+type pair struct {
+	key   string
+	value any
+	line  int
+}
+
+// pairList is a YAML mapping that keeps the order its keys were written in.
+type pairList []pair
+
+func (l *pairList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping", node.Line)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k, v := node.Content[i], node.Content[i+1]
+		value, err := nodeValue(v)
+		if err != nil {
+			return err
+		}
+		*l = append(*l, pair{key: k.Value, value: value, line: k.Line})
+	}
+	return nil
+}
+
+// stringList accepts a single string or a list of strings.
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = stringList{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// nodeValue converts a YAML node into a Document value, keeping the key order
+// of mappings.
+func nodeValue(node *yaml.Node) (any, error) {
+	switch node.Kind {
+	case 0:
+		return nil, nil
+	case yaml.AliasNode:
+		return nodeValue(node.Alias)
+	case yaml.ScalarNode:
+		switch node.ShortTag() {
+		case "!!null":
+			return nil, nil
+		case "!!bool":
+			var b bool
+			err := node.Decode(&b)
+			return b, err
+		case "!!int", "!!float":
+			var f float64
+			if err := node.Decode(&f); err != nil {
+				return nil, err
+			}
+			if n, err := strconv.ParseInt(node.Value, 10, 64); err == nil {
+				return json.Number(strconv.FormatInt(n, 10)), nil
+			}
+			return normalizeValue(f), nil
+		default:
+			return node.Value, nil
+		}
+	case yaml.MappingNode:
+		obj := newObject()
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v, err := nodeValue(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			obj.set(node.Content[i].Value, v)
+		}
+		return obj, nil
+	case yaml.SequenceNode:
+		arr := make([]any, 0, len(node.Content))
+		for _, c := range node.Content {
+			v, err := nodeValue(c)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	}
+	return nil, fmt.Errorf("line %d: unsupported value", node.Line)
+}
+
+// LoadRuleset reads and compiles the ruleset at path; an empty path loads
+// the embedded default ruleset. Lookup files are resolved relative to the
+// directory of path.
+func LoadRuleset(path string) (*Ruleset, error) {
+	if path == "" {
+		return CompileRuleset(defaultRules)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := compileRuleset(data, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	rs.files = append([]string{path}, rs.files...)
+	return rs, nil
+}
+
+// CompileRuleset parses a YAML ruleset and compiles it into rules. Every
+// reference (rules, lookup tables, built-ins, regular expressions) is
+// checked, so a ruleset that compiles can be applied to any event. Lookup
+// files are resolved relative to the working directory.
+func CompileRuleset(data []byte) (*Ruleset, error) {
+	return compileRuleset(data, "")
+}
+
+func compileRuleset(data []byte, dir string) (*Ruleset, error) {
+	var f ruleFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("parse ruleset: %w", err)
+	}
+
+	rs := &Ruleset{
+		Version:   f.Version,
+		pipelines: map[string][]rule{},
+		rules:     map[string]rule{},
+	}
+
+	// The hash covers the rule file and, in name order, every lookup file.
+	hash := sha256.New()
+	hash.Write(data)
+	lookups := map[string]pairList{}
+	for name, table := range f.Lookups {
+		lookups[name] = table
+	}
+	names := make([]string, 0, len(f.LookupFiles))
+	for name := range f.LookupFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, dup := lookups[name]; dup {
+			return nil, fmt.Errorf("lookup %s defined twice", name)
+		}
+		path := f.LookupFiles[name]
+		if !filepath.IsAbs(path) && dir != "" {
+			path = filepath.Join(dir, path)
+		}
+		table, raw, err := loadLookupFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("lookup %s: %w", name, err)
+		}
+		lookups[name] = table
+		rs.files = append(rs.files, path)
+		fmt.Fprintf(hash, "\x00%s\x00", name)
+		hash.Write(raw)
+	}
+	rs.Hash = hex.EncodeToString(hash.Sum(nil))[:12]
+
+	c := &compiler{lookups: lookups}
+	for _, spec := range f.Rules {
+		if spec.Name == "" {
+			return nil, errors.New("rule without a name")
+		}
+		if _, dup := rs.rules[spec.Name]; dup {
+			return nil, fmt.Errorf("rule %s defined twice", spec.Name)
+		}
+		r, err := c.rule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", spec.Name, err)
+		}
+		rs.rules[spec.Name] = r
+	}
+
+	seen := map[string]bool{}
+	for _, spec := range f.Categories {
+		if spec.Name == "" {
+			return nil, errors.New("category without a name")
+		}
+		if seen[spec.Name] {
+			return nil, fmt.Errorf("category %s defined twice", spec.Name)
+		}
+		seen[spec.Name] = true
+		when, err := c.conditions(spec.When)
+		if err != nil {
+			return nil, fmt.Errorf("category %s: %w", spec.Name, err)
+		}
+		rs.categories = append(rs.categories, category{name: spec.Name, when: when})
+	}
+
+	var err error
+	if rs.before, err = rs.lookupRules("before", f.Before); err != nil {
+		return nil, err
+	}
+	if rs.after, err = rs.lookupRules("after", f.After); err != nil {
+		return nil, err
+	}
+	for name, names := range f.Pipelines {
+		if !seen[name] {
+			return nil, fmt.Errorf("pipeline %s: no such category", name)
+		}
+		if rs.pipelines[name], err = rs.lookupRules("pipeline "+name, names); err != nil {
+			return nil, err
+		}
+	}
+	return rs, nil
+}
+
+// loadLookupFile reads a lookup table kept in its own YAML file: a mapping
+// of keys to values, checked in the order written.
+func loadLookupFile(path string) (pairList, []byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var table pairList
+	if err := yaml.Unmarshal(raw, &table); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return table, raw, nil
+}
+
+func (rs *Ruleset) lookupRules(where string, names []string) ([]rule, error) {
+	rules := make([]rule, 0, len(names))
+	for _, name := range names {
+		r, ok := rs.rules[name]
+		if !ok {
+			return nil, fmt.Errorf("%s: no such rule %s", where, name)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// condition reports whether an event matches.
+type condition func(*Document) bool
+
+// action edits an event.
+type action func(*Document)
+
+type step struct {
+	when condition
+	run  action
+}
+
+type compiler struct {
+	lookups map[string]pairList
+}
+
+func (c *compiler) rule(spec ruleSpec) (rule, error) {
+	when, err := c.conditions(spec.When)
+	if err != nil {
+		return rule{}, err
+	}
+	steps, err := c.steps(spec.Steps)
+	if err != nil {
+		return rule{}, err
+	}
+	return rule{name: spec.Name, apply: func(doc *Document) {
+		if when != nil && !when(doc) {
+			return
+		}
+		runSteps(doc, steps)
+	}}, nil
+}
+
+func runSteps(doc *Document, steps []step) {
+	for _, s := range steps {
+		if s.when == nil || s.when(doc) {
+			s.run(doc)
+		}
+	}
+}
+
+func (c *compiler) steps(specs []stepSpec) ([]step, error) {
+	steps := make([]step, 0, len(specs))
+	for i, spec := range specs {
+		s, err := c.step(spec)
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %w", i+1, err)
+		}
+		steps = append(steps, s)
+	}
+	return steps, nil
+}
+
+func (c *compiler) step(spec stepSpec) (step, error) {
+	when, err := c.conditions(spec.When)
+	if err != nil {
+		return step{}, err
+	}
+
+	var actions []action
+	add := func(a action, err error) error {
+		if err != nil {
+			return err
+		}
+		actions = append(actions, a)
+		return nil
+	}
+	if spec.Rename != nil {
+		err = add(mappingAction(spec.Rename, true))
+	}
+	if err == nil && spec.Copy != nil {
+		err = add(mappingAction(spec.Copy, false))
+	}
+	if err == nil && spec.Set != nil {
+		err = add(setAction(spec.Set))
+	}
+	if err == nil && spec.Delete != nil {
+		err = add(deleteAction(spec.Delete), nil)
+	}
+	if err == nil && spec.Lowercase != nil {
+		err = add(lowercaseAction(spec.Lowercase), nil)
+	}
+	if err == nil && spec.Split != nil {
+		err = add(splitAction(spec.Split))
+	}
+	if err == nil && spec.Lookup != nil {
+		err = add(c.lookupAction(spec.Lookup))
+	}
+	if err == nil && spec.First != nil {
+		err = add(c.firstAction(spec.First))
+	}
+	if err == nil && spec.Call != "" {
+		err = add(callAction(spec.Call, spec.With))
+	}
+	if err != nil {
+		return step{}, err
+	}
+	if spec.With != nil && spec.Call == "" {
+		return step{}, errors.New("with without call")
+	}
+
+	switch len(actions) {
+	case 0:
+		return step{}, errors.New("no action")
+	case 1:
+		return step{when: when, run: actions[0]}, nil
+	default:
+		return step{}, errors.New("more than one action; use one step per action")
+	}
+}
+
+func mappingAction(pairs pairList, move bool) (action, error) {
+	mapping := make([]fieldMapping, 0, len(pairs))
+	for _, p := range pairs {
+		to, ok := p.value.(string)
+		if !ok || to == "" || p.key == "" {
+			return nil, fmt.Errorf("line %d: mapping entries must be \"source: target\" field names", p.line)
+		}
+		mapping = append(mapping, fieldMapping{from: p.key, to: to})
+	}
+	if move {
+		return func(doc *Document) { renameFields(doc, mapping) }, nil
+	}
+	return func(doc *Document) { copyFields(doc, mapping) }, nil
+}
+
+func setAction(spec *setSpec) (action, error) {
+	if spec.Field == "" {
+		return nil, errors.New("set: field is required")
+	}
+	value, err := nodeValue(&spec.Value)
+	if err != nil {
+		return nil, fmt.Errorf("set: %w", err)
+	}
+	field := spec.Field
+	return func(doc *Document) { doc.Set(field, value) }, nil
+}
+
+func deleteAction(fields []string) action {
+	return func(doc *Document) {
+		for _, f := range fields {
+			doc.Delete(f)
+		}
+	}
+}
+
+func lowercaseAction(fields []string) action {
+	return func(doc *Document) {
+		for _, f := range fields {
+			if v, ok := doc.Get(f); ok {
+				if s, ok := v.(string); ok {
+					doc.Set(f, strings.ToLower(s))
+				}
+			}
+		}
+	}
+}
+
+func splitAction(spec *splitSpec) (action, error) {
+	if spec.Field == "" || spec.Separator == "" {
+		return nil, errors.New("split: field and separator are required")
+	}
+	from, sep, to := spec.Field, spec.Separator, spec.To
+	if to == "" {
+		to = from
+	}
+	return func(doc *Document) {
+		v, ok := doc.Get(from)
+		if !ok {
+			return
+		}
+		if s, ok := v.(string); ok {
+			doc.Set(to, strings.Split(s, sep))
+		}
+	}, nil
+}
+
+func (c *compiler) lookupAction(spec *lookupSpec) (action, error) {
+	fields := spec.Fields
+	if spec.Field != "" {
+		fields = append([]string{spec.Field}, fields...)
+	}
+	if len(fields) == 0 || spec.To == "" {
+		return nil, errors.New("lookup: field and to are required")
+	}
+
+	table := spec.Entries
+	if spec.Table != "" {
+		if table != nil {
+			return nil, errors.New("lookup: use either table or entries")
+		}
+		var ok bool
+		if table, ok = c.lookups[spec.Table]; !ok {
+			return nil, fmt.Errorf("lookup: no such table %s", spec.Table)
+		}
+	}
+
+	var matches func(value, key string) bool
+	switch spec.Match {
+	case "", "exact":
+		matches = func(value, key string) bool { return value == key }
+		if spec.IgnoreCase {
+			matches = func(value, key string) bool {
+				return strings.ToLower(value) == strings.ToLower(key)
+			}
+		}
+	case "contains":
+		matches = strings.Contains
+		if spec.IgnoreCase {
+			matches = func(value, key string) bool {
+				return strings.Contains(strings.ToLower(value), strings.ToLower(key))
+			}
+		}
+	default:
+		return nil, fmt.Errorf("lookup: unknown match %q", spec.Match)
+	}
+
+	then, err := c.steps(spec.Then)
+	if err != nil {
+		return nil, fmt.Errorf("lookup then: %w", err)
+	}
+
+	to := spec.To
+	return func(doc *Document) {
+		for _, f := range fields {
+			value := doc.GetString(f)
+			if value == "" {
+				continue
+			}
+			for _, p := range table {
+				if matches(value, p.key) {
+					doc.Set(to, p.value)
+					runSteps(doc, then)
+					return
+				}
+			}
+		}
+	}, nil
+}
+
+func (c *compiler) firstAction(specs []stepSpec) (action, error) {
+	steps, err := c.steps(specs)
+	if err != nil {
+		return nil, fmt.Errorf("first: %w", err)
+	}
+	return func(doc *Document) {
+		for _, s := range steps {
+			if s.when == nil || s.when(doc) {
+				s.run(doc)
+				return
+			}
+		}
+	}, nil
+}
+
+// builtins are the rules written in Go that rulesets can call.
+var builtins = map[string]func(args map[string]string) (action, error){
+	"cleanFields": func(map[string]string) (action, error) {
+		return cleanFields, nil
+	},
+	"addWazuhLogID": func(map[string]string) (action, error) {
+		return addWazuhLogID, nil
+	},
+	"extractMitreInfo": func(args map[string]string) (action, error) {
+		field := args["field"]
+		if field == "" {
+			return nil, errors.New("extractMitreInfo: with.field is required")
+		}
+		return func(doc *Document) { extractMitreInfo(doc, doc.GetString(field)) }, nil
+	},
+}
+
+func callAction(name string, args map[string]string) (action, error) {
+	build, ok := builtins[name]
+	if !ok {
+		return nil, fmt.Errorf("call: no such built-in %s", name)
+	}
+	return build(args)
+}
+
+func (c *compiler) conditions(specs []conditionSpec) (condition, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	conds := make([]condition, 0, len(specs))
+	for _, spec := range specs {
+		cond, err := c.condition(spec)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+	}
+	return allOf(conds), nil
+}
+
+func allOf(conds []condition) condition {
+	return func(doc *Document) bool {
+		for _, cond := range conds {
+			if !cond(doc) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func (c *compiler) condition(spec conditionSpec) (condition, error) {
+	var tests []condition
+
+	if spec.Any != nil || spec.All != nil {
+		if spec.Field != "" {
+			return nil, errors.New("condition: any/all cannot be combined with field")
+		}
+		for _, group := range []struct {
+			specs []conditionSpec
+			any   bool
+		}{{spec.Any, true}, {spec.All, false}} {
+			if group.specs == nil {
+				continue
+			}
+			conds := make([]condition, 0, len(group.specs))
+			for _, s := range group.specs {
+				cond, err := c.condition(s)
+				if err != nil {
+					return nil, err
+				}
+				conds = append(conds, cond)
+			}
+			if group.any {
+				tests = append(tests, func(doc *Document) bool {
+					for _, cond := range conds {
+						if cond(doc) {
+							return true
+						}
+					}
+					return false
+				})
+			} else {
+				tests = append(tests, allOf(conds))
+			}
+		}
+	} else {
+		if spec.Field == "" {
+			return nil, errors.New("condition: field is required")
+		}
+		var err error
+		if tests, err = fieldTests(spec); err != nil {
+			return nil, fmt.Errorf("condition on %s: %w", spec.Field, err)
+		}
+	}
+
+	test := allOf(tests)
+	if spec.Not {
+		return func(doc *Document) bool { return !test(doc) }, nil
+	}
+	return test, nil
+}
+
+func fieldTests(spec conditionSpec) ([]condition, error) {
+	field := spec.Field
+	fold := func(s string) string { return s }
+	if spec.IgnoreCase {
+		fold = strings.ToLower
+	}
+
+	var tests []condition
+	if spec.Exists != nil {
+		want := *spec.Exists
+		tests = append(tests, func(doc *Document) bool { return doc.Exists(field) == want })
+	}
+	if spec.Equals != nil {
+		want := fold(*spec.Equals)
+		tests = append(tests, func(doc *Document) bool { return fold(doc.GetString(field)) == want })
+	}
+	if spec.In != nil {
+		set := make(map[string]bool, len(spec.In))
+		for _, v := range spec.In {
+			set[fold(v)] = true
+		}
+		tests = append(tests, func(doc *Document) bool { return set[fold(doc.GetString(field))] })
+	}
+	if spec.Contains != nil {
+		subs := make([]string, len(spec.Contains))
+		for i, s := range spec.Contains {
+			subs[i] = fold(s)
+		}
+		tests = append(tests, func(doc *Document) bool {
+			value := fold(doc.GetString(field))
+			for _, s := range subs {
+				if strings.Contains(value, s) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	if spec.Regex != nil {
+		expr := *spec.Regex
+		if spec.IgnoreCase {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		tests = append(tests, func(doc *Document) bool { return re.MatchString(doc.GetString(field)) })
+	}
+	if spec.Gte != nil {
+		min := *spec.Gte
+		tests = append(tests, func(doc *Document) bool { return doc.GetInt(field) >= min })
+	}
+	if spec.Lte != nil {
+		max := *spec.Lte
+		tests = append(tests, func(doc *Document) bool { return doc.GetInt(field) <= max })
+	}
+
+	if len(tests) == 0 {
+		return nil, errors.New("no test (equals, contains, regex, exists, in, gte, lte)")
+	}
+	return tests, nil
+}
@@ -0,0 +1,226 @@
+package normalizer
+
+import (
+	"strings"
+	"testing"
+)
+
+// compileRule compiles a ruleset holding the rule r, indented under
+// "rules:", plus extra top-level YAML, and returns r.
+func compileRule(t *testing.T, r, extra string) rule {
+	t.Helper()
+	rs, err := CompileRuleset([]byte(extra + "\nrules:\n  - name: r\n" + indent(r, "    ")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs.rules["r"]
+}
+
+func indent(s, prefix string) string {
+	return prefix + strings.ReplaceAll(strings.TrimSpace(s), "\n", "\n"+prefix) + "\n"
+}
+
+func TestRuleConditions(t *testing.T) {
+	const event = `{"data":{"user":"Alice","ip":"10.0.0.7","level":"12","tags":"malware,c2"},"count":3}`
+
+	for name, tc := range map[string]struct {
+		when string
+		want bool
+	}{
+		"equals":                 {`{field: data.user, equals: Alice}`, true},
+		"equals other case":      {`{field: data.user, equals: alice}`, false},
+		"equals ignore case":     {`{field: data.user, equals: alice, ignore_case: true}`, true},
+		"equals number":          {`{field: count, equals: "3"}`, true},
+		"equals missing":         {`{field: data.group, equals: ""}`, true},
+		"contains":               {`{field: data.tags, contains: c2}`, true},
+		"contains any of":        {`{field: data.tags, contains: [worm, malware]}`, true},
+		"contains none":          {`{field: data.tags, contains: [worm, MALWARE]}`, false},
+		"contains ignore case":   {`{field: data.tags, contains: MALWARE, ignore_case: true}`, true},
+		"regex":                  {`{field: data.ip, regex: '^10\.'}`, true},
+		"regex no match":         {`{field: data.ip, regex: '^192\.'}`, false},
+		"regex ignore case":      {`{field: data.user, regex: '^ali', ignore_case: true}`, true},
+		"exists":                 {`{field: data.ip, exists: true}`, true},
+		"exists missing":         {`{field: data.group, exists: true}`, false},
+		"not exists":             {`{field: data.group, exists: false}`, true},
+		"in":                     {`{field: data.user, in: [Bob, Alice]}`, true},
+		"in other case":          {`{field: data.user, in: [bob, alice]}`, false},
+		"in ignore case":         {`{field: data.user, in: [bob, alice], ignore_case: true}`, true},
+		"gte":                    {`{field: data.level, gte: 12}`, true},
+		"gte below":              {`{field: data.level, gte: 13}`, false},
+		"lte":                    {`{field: count, lte: 3}`, true},
+		"lte above":              {`{field: count, lte: 2}`, false},
+		"range":                  {`{field: data.level, gte: 10, lte: 15}`, true},
+		"range outside":          {`{field: data.level, gte: 1, lte: 11}`, false},
+		"tests of one condition": {`{field: data.user, equals: Alice, regex: '^B'}`, false},
+		"not":                    {`{field: data.user, equals: Alice, not: true}`, false},
+		"not missing":            {`{field: data.group, exists: true, not: true}`, true},
+		"any":                    {`{any: [{field: data.user, equals: Bob}, {field: count, gte: 3}]}`, true},
+		"any none":               {`{any: [{field: data.user, equals: Bob}, {field: count, gte: 4}]}`, false},
+		"all":                    {`{all: [{field: data.user, equals: Alice}, {field: count, gte: 3}]}`, true},
+		"all but one":            {`{all: [{field: data.user, equals: Alice}, {field: count, gte: 4}]}`, false},
+		"not any":                {`{any: [{field: data.user, equals: Bob}], not: true}`, true},
+		"nested":                 {`{all: [{any: [{field: data.ip, regex: '^10\.'}, {field: data.ip, regex: '^172\.'}]}, {field: data.tags, contains: c2}]}`, true},
+		"several":                {"{field: data.user, equals: Alice}\n  - {field: count, lte: 2}", false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := compileRule(t, "when:\n  - "+tc.when+"\nsteps:\n  - set: {field: matched, value: true}", "")
+			doc, _ := ParseDocument(event)
+			r.apply(doc)
+			if got := doc.Exists("matched"); got != tc.want {
+				t.Errorf("matched = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRuleActions(t *testing.T) {
+	const lookups = `lookups:
+  levels:
+    "3": low
+    "12": high`
+
+	for name, tc := range map[string]struct {
+		steps, event, want string
+	}{
+		"rename": {
+			`- rename: {data.srcip: source.ip, data.dstip: destination.ip, data.none: x}`,
+			`{"data":{"srcip":"10.0.0.1","dstip":"10.0.0.2"}}`,
+			`{"data":{},"source":{"ip":"10.0.0.1"},"destination":{"ip":"10.0.0.2"}}`,
+		},
+		"copy": {
+			`- copy: {data.user: user.name}`,
+			`{"data":{"user":"alice"}}`,
+			`{"data":{"user":"alice"},"user":{"name":"alice"}}`,
+		},
+		"set": {
+			`- set: {field: event.kind, value: alert}
+- set: {field: event.severity, value: 3}
+- set: {field: event.tags, value: [a, {b: true}]}
+- set: {field: event.tags.-1, value: null}`,
+			`{}`,
+			`{"event":{"kind":"alert","severity":3,"tags":["a",{"b":true},null]}}`,
+		},
+		"delete": {
+			`- delete: data.level
+- delete: [data.a, data.missing]`,
+			`{"data":{"level":"3","a":1,"b":2}}`,
+			`{"data":{"b":2}}`,
+		},
+		"lowercase": {
+			`- lowercase: [data.user, data.count, data.missing]`,
+			`{"data":{"user":"ALICE","count":3}}`,
+			`{"data":{"user":"alice","count":3}}`,
+		},
+		"split": {
+			`- split: {field: data.tags, separator: ",", to: tags}
+- split: {field: data.path, separator: /}`,
+			`{"data":{"tags":"a,b","path":"x/y"}}`,
+			`{"data":{"tags":"a,b","path":["x","y"]},"tags":["a","b"]}`,
+		},
+		"lookup table": {
+			`- lookup: {field: data.level, table: levels, to: event.severity_name}`,
+			`{"data":{"level":"12"}}`,
+			`{"data":{"level":"12"},"event":{"severity_name":"high"}}`,
+		},
+		"lookup entries": {
+			`- lookup:
+    fields: [data.program, data.process]
+    match: contains
+    ignore_case: true
+    entries: {sshd: ssh, nginx: web}
+    to: service.type
+    then:
+      - set: {field: service.known, value: true}`,
+			`{"data":{"process":"/usr/sbin/NGINX"}}`,
+			`{"data":{"process":"/usr/sbin/NGINX"},"service":{"type":"web","known":true}}`,
+		},
+		"lookup miss": {
+			`- lookup: {field: data.level, table: levels, to: event.severity_name, then: [{set: {field: x, value: 1}}]}`,
+			`{"data":{"level":"7"}}`,
+			`{"data":{"level":"7"}}`,
+		},
+		"first": {
+			`- first:
+    - when: [{field: data.ip, regex: '^192\.'}]
+      set: {field: network.zone, value: lan}
+    - when: [{field: data.ip, exists: true}]
+      set: {field: network.zone, value: wan}
+    - set: {field: network.zone, value: unknown}`,
+			`{"data":{"ip":"203.0.113.1"}}`,
+			`{"data":{"ip":"203.0.113.1"},"network":{"zone":"wan"}}`,
+		},
+		"step when": {
+			`- when: [{field: data.user, exists: true}]
+  set: {field: has_user, value: true}
+- when: [{field: data.group, exists: true}]
+  set: {field: has_group, value: true}`,
+			`{"data":{"user":"alice"}}`,
+			`{"data":{"user":"alice"},"has_user":true}`,
+		},
+		"call": {
+			`- call: extractMitreInfo
+  with: {field: data.technique}
+- call: cleanFields`,
+			`{"data":{"technique":"TechniqueID=T1059,TechniqueName=Command and Scripting Interpreter","level":"3"}}`,
+			`{"data":{"technique":"TechniqueID=T1059,TechniqueName=Command and Scripting Interpreter"},"rule":{"mitre":{"id":"T1059","technique":"Command and Scripting Interpreter"}}}`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := compileRule(t, "steps:\n"+indent(tc.steps, "  "), lookups)
+			doc, err := ParseDocument(tc.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.apply(doc)
+			if got := doc.String(); got != tc.want {
+				t.Errorf("got  %s\nwant %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompileRulesetErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		ruleset, want string
+	}{
+		"unknown top-level field":    {"rulez: []", "field rulez not found"},
+		"unknown rule field":         {"rules: [{name: r, stepz: []}]", "field stepz not found"},
+		"unknown condition field":    {"rules: [{name: r, when: [{field: a, equal: b}], steps: [{delete: a}]}]", "field equal not found"},
+		"unknown step field":         {"rules: [{name: r, steps: [{remove: a}]}]", "field remove not found"},
+		"unnamed rule":               {"rules: [{steps: [{delete: a}]}]", "rule without a name"},
+		"duplicate rule":             {"rules: [{name: r, steps: [{delete: a}]}, {name: r, steps: [{delete: b}]}]", "rule r defined twice"},
+		"no action":                  {"rules: [{name: r, steps: [{when: [{field: a, exists: true}]}]}]", "rule r: step 1: no action"},
+		"two actions":                {"rules: [{name: r, steps: [{delete: a, lowercase: b}]}]", "more than one action"},
+		"condition without field":    {"rules: [{name: r, when: [{equals: a}], steps: [{delete: a}]}]", "field is required"},
+		"condition without test":     {"rules: [{name: r, when: [{field: a}], steps: [{delete: a}]}]", "condition on a: no test"},
+		"any with field":             {"rules: [{name: r, when: [{field: a, any: [{field: b, exists: true}]}], steps: [{delete: a}]}]", "any/all cannot be combined"},
+		"bad regex":                  {"rules: [{name: r, when: [{field: a, regex: '('}], steps: [{delete: a}]}]", "condition on a: error parsing regexp"},
+		"bad mapping":                {"rules: [{name: r, steps: [{rename: {a: [b]}}]}]", "mapping entries must be"},
+		"set without field":          {"rules: [{name: r, steps: [{set: {value: 1}}]}]", "set: field is required"},
+		"split without separator":    {"rules: [{name: r, steps: [{split: {field: a}}]}]", "split: field and separator are required"},
+		"lookup without to":          {"rules: [{name: r, steps: [{lookup: {field: a, entries: {x: y}}}]}]", "lookup: field and to are required"},
+		"lookup unknown table":       {"rules: [{name: r, steps: [{lookup: {field: a, table: t, to: b}}]}]", "no such table t"},
+		"lookup table and entries":   {"lookups: {t: {x: y}}\nrules: [{name: r, steps: [{lookup: {field: a, table: t, entries: {x: y}, to: b}}]}]", "either table or entries"},
+		"lookup unknown match":       {"rules: [{name: r, steps: [{lookup: {field: a, entries: {x: y}, match: prefix, to: b}}]}]", `unknown match "prefix"`},
+		"lookup bad then":            {"rules: [{name: r, steps: [{lookup: {field: a, entries: {x: y}, to: b, then: [{}]}}]}]", "lookup then: step 1: no action"},
+		"first bad step":             {"rules: [{name: r, steps: [{first: [{delete: a}, {}]}]}]", "first: step 2: no action"},
+		"unknown built-in":           {"rules: [{name: r, steps: [{call: nope}]}]", "no such built-in nope"},
+		"built-in missing argument":  {"rules: [{name: r, steps: [{call: extractMitreInfo}]}]", "with.field is required"},
+		"with without call":          {"rules: [{name: r, steps: [{delete: a, with: {x: y}}]}]", "with without call"},
+		"unknown rule in pipeline":   {"categories: [{name: c}]\npipelines: {c: [r]}", "pipeline c: no such rule r"},
+		"unknown pipeline category":  {"rules: [{name: r, steps: [{delete: a}]}]\npipelines: {c: [r]}", "pipeline c: no such category"},
+		"unknown rule in before":     {"before: [r]", "before: no such rule r"},
+		"duplicate category":         {"categories: [{name: c}, {name: c}]", "category c defined twice"},
+		"bad category condition":     {"categories: [{name: c, when: [{field: a}]}]", "category c: condition on a"},
+		"missing lookup file":        {"lookup_files: {t: /nonexistent/table.yaml}", "lookup t:"},
+		"lookup defined twice":       {"lookups: {t: {x: y}}\nlookup_files: {t: /nonexistent/table.yaml}", "lookup t defined twice"},
+		"lookup table not a mapping": {"lookups: {t: [x]}", "expected a mapping"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := CompileRuleset([]byte(tc.ruleset))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("error %v, want one containing %q", err, tc.want)
+			}
+		})
+	}
+}
@@ -1,61 +1,264 @@
 package normalizer
 
 import (
-	"strings"
+	"fmt"
+	"log"
+	"sync/atomic"
 
-	"github.com/tidwall/gjson"
+	"github.com/izzatbey/soc-norm-events/internal/config"
 )
 
+// rule is one named step of the normalization pipeline. Rules edit the
+// shared Document in place.
+type rule struct {
+	name  string
+	apply func(*Document)
+}
+
+// Ruleset is a compiled rule file: how events are assigned a source
+// category and which rules run on them.
+type Ruleset struct {
+	// Version is the version label declared in the rule file and Hash
+	// identifies its exact content, lookup files included. Both are added
+	// to every event as normalizer.ruleset.
+	Version string
+	Hash    string
+
+	files      []string
+	categories []category
+	before     []rule
+	pipelines  map[string][]rule
+	after      []rule
+	rules      map[string]rule
+}
+
+// category is a source category with the conditions that select it.
+type category struct {
+	name string
+	when condition
+}
+
+var activeRuleset atomic.Pointer[Ruleset]
+
+func init() {
+	rs, err := CompileRuleset(defaultRules)
+	if err != nil {
+		panic(fmt.Sprintf("default ruleset: %v", err))
+	}
+	activeRuleset.Store(rs)
+}
+
+// UseRuleset makes rs the ruleset applied to every following event.
+func UseRuleset(rs *Ruleset) {
+	activeRuleset.Store(rs)
+}
+
+// ActiveRuleset returns the ruleset currently applied to events.
+func ActiveRuleset() *Ruleset {
+	return activeRuleset.Load()
+}
+
+// String identifies the ruleset in logs.
+func (rs *Ruleset) String() string {
+	return fmt.Sprintf("version=%s hash=%s", rs.Version, rs.Hash)
+}
+
+// ConfigureRules applies the ruleset, rule failure and mapping collision
+// policies of cfg.
+func ConfigureRules(cfg *config.Config) error {
+	if err := SetFailurePolicies(cfg.RuleFailurePolicy); err != nil {
+		return err
+	}
+	if err := SetCollisionPolicies(cfg.CollisionPolicy); err != nil {
+		return err
+	}
+	rs, err := LoadRuleset(cfg.RulesFile)
+	if err != nil {
+		return fmt.Errorf("load ruleset: %w", err)
+	}
+	UseRuleset(rs)
+	if cfg.RulesFile != "" {
+		log.Printf("📜 Using ruleset %s from %s", rs, cfg.RulesFile)
+	} else {
+		log.Printf("📜 Using default ruleset %s", rs)
+	}
+	return nil
+}
+
 func ApplyRules(raw string) string {
+	normalized, _ := applyRules(raw)
+	return normalized
+}
+
+// applyRules parses the event once, runs the active ruleset on the Document
+// with every rule isolated by runRule and serializes the result once. It
+// stops at the first rule whose failure policy is FailQuarantine and returns
+// the event as it was before that rule together with the *RuleError. Input
+// that is not a JSON object is returned unchanged.
+func applyRules(raw string) (string, error) {
+	return applyRuleset(ActiveRuleset(), raw, "")
+}
+
+// applyRuleset is applyRules with an explicit ruleset and, unless category
+// is empty, a forced source category.
+func applyRuleset(rs *Ruleset, raw, category string) (string, error) {
 	if raw == "" {
-		return raw
+		return raw, nil
+	}
+	doc, err := ParseDocument(raw)
+	if err != nil || !doc.IsObject() {
+		return raw, nil
 	}
-	raw = hostnameRemap(raw)
-	category := SourceCategory(raw)
 
-	if rules, exists := ruleRouter[category]; exists {
-		for _, ruleFunc := range rules {
-			raw = ruleFunc(raw)
+	if err := rs.run(doc, category, nil); err != nil {
+		return doc.String(), err
+	}
+	return doc.String(), nil
+}
+
+// run applies the before rules, the pipeline of the event's category (the
+// detected one when category is empty), the after rules and stage-6
+// enrichment if configured, then records the ruleset in the event.
+// t, if not nil, records every rule's changes.
+func (rs *Ruleset) run(doc *Document, category string, t *tracer) error {
+	t.phase("before")
+	if err := runRules(doc, rs.before, t); err != nil {
+		return err
+	}
+	if category == "" {
+		category = rs.category(doc)
+	}
+	t.category(category)
+	if err := runRules(doc, rs.pipelines[category], t); err != nil {
+		return err
+	}
+	t.phase("after")
+	if err := runRules(doc, rs.after, t); err != nil {
+		return err
+	}
+	if e := activeEnricher.Load(); e != nil {
+		t.phase("enrich")
+		t.before(doc)
+		e.enrich(doc, category)
+		t.after(doc, "stage6", nil)
+	}
+	doc.Set("normalizer.ruleset.version", rs.Version)
+	doc.Set("normalizer.ruleset.hash", rs.Hash)
+	doc.commit()
+	return nil
+}
+
+// runRules applies rules in order and stops at the first rule that fails
+// with the FailQuarantine policy.
+func runRules(doc *Document, rules []rule, t *tracer) error {
+	for _, r := range rules {
+		t.before(doc)
+		ruleErr := runRule(doc, r)
+		t.after(doc, r.name, ruleErr)
+		if ruleErr != nil && failurePolicy(r.name) == FailQuarantine {
+			return ruleErr
+		}
+	}
+	return nil
+}
+
+// Categories returns the source categories of the ruleset in detection
+// order.
+func (rs *Ruleset) Categories() []string {
+	names := make([]string, len(rs.categories))
+	for i, c := range rs.categories {
+		names[i] = c.name
+	}
+	return names
+}
+
+// category returns the name of the first category whose conditions match,
+// or "" if none does.
+func (rs *Ruleset) category(doc *Document) string {
+	for _, c := range rs.categories {
+		if c.when == nil || c.when(doc) {
+			return c.name
 		}
 	}
+	return ""
+}
+
+// runRule applies r and recovers from a panic inside it. A panicking rule's
+// partial edits are rolled back and a *RuleError is returned; unless the
+// rule's failure policy is FailQuarantine, the event is also marked in
+// normalizer.failed_rules so it can continue through the pipeline.
+func runRule(doc *Document, r rule) (ruleErr *RuleError) {
+	m := doc.mark()
+	defer func() {
+		p := recover()
+		if p == nil {
+			doc.commit()
+			return
+		}
+		doc.rollback(m)
 
-	raw = standardizeEvent(raw)
-	raw = ApplyAlertRules(raw)
-	return raw
+		ruleErr = &RuleError{
+			Rule:    r.name,
+			EventID: doc.GetString("id"),
+			Agent:   doc.GetString("agent.name"),
+			RuleID:  doc.GetString("rule.id"),
+			Panic:   p,
+		}
+		recordRuleFailure(r.name)
+		log.Printf("❌ %v", ruleErr)
+
+		if failurePolicy(r.name) != FailQuarantine {
+			doc.Set("normalizer.failed_rules.-1", r.name)
+			doc.commit()
+		}
+	}()
+	r.apply(doc)
+	return nil
+}
+
+// RuleError describes a rule that panicked while normalizing an event.
+type RuleError struct {
+	Rule    string
+	EventID string
+	Agent   string
+	RuleID  string
+	Panic   any
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("rule %s panicked on event id=%q agent=%q rule.id=%q: %v",
+		e.Rule, e.EventID, e.Agent, e.RuleID, e.Panic)
 }
 
+// SourceCategory returns the source category the active ruleset assigns to
+// raw.
 func SourceCategory(raw string) string {
-	decoder := strings.ToLower(gjson.Get(raw, "decoder.name").String())
-	location := strings.ToLower(gjson.Get(raw, "location").String())
-
-	switch {
-	case strings.Contains(decoder, "fortigate"):
-		return "fortigate"
-	case strings.Contains(decoder, "sysmon-linux"):
-		return "sysmon-linux"
-	case strings.Contains(decoder, "windows_eventchannel"):
-		return "sysmon-windows"
-	case strings.Contains(decoder, "web-accesslog") && strings.Contains(location, "nginx"):
-		return "nginx"
-	default:
-		return "hostname"
-	}
-}
-
-var ruleRouter = map[string][]func(string) string{
-	"fortigate": {
-		fortigateRemap,
-	},
-	"sysmon-linux": {
-		sysmonLinuxRemap,
-	},
-	"sysmon-windows": {
-		sysmonWinRemap,
-	},
-	"nginx": {
-		nginxRemap,
-	},
-	"hostname": {
-		hostnameRemap,
-	},
+	return ActiveRuleset().SourceCategory(raw)
+}
+
+// SourceCategory returns the source category rs assigns to raw.
+func (rs *Ruleset) SourceCategory(raw string) string {
+	doc, err := ParseDocument(raw)
+	if err != nil {
+		doc = &Document{}
+	}
+	return rs.category(doc)
+}
+
+// ApplyAlertRules runs only the alert rules (IRIS severity, alert source and
+// MITRE tactic ID) of the active ruleset on raw.
+func ApplyAlertRules(raw string) string {
+	doc, err := ParseDocument(raw)
+	if err != nil || !doc.IsObject() {
+		return raw
+	}
+	rs := ActiveRuleset()
+	var rules []rule
+	for _, name := range []string{"mapIrisSeverity", "mapAlertSource", "mapMitreTacticID"} {
+		if r, ok := rs.rules[name]; ok {
+			rules = append(rules, r)
+		}
+	}
+	runRules(doc, rules, nil)
+	return doc.String()
 }
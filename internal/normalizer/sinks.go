@@ -0,0 +1,345 @@
+package normalizer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/izzatbey/soc-norm-events/internal/config"
+)
+
+// sinkFlushInterval bounds how long the file, stdout and HTTP sinks hold
+// buffered events.
+const sinkFlushInterval = time.Second
+
+// sinkMaxPending bounds the events the file, stdout and HTTP sinks hold
+// while their output fails; beyond it Write refuses new events.
+const sinkMaxPending = 10000
+
+// sinkBuffer holds the events a sink has accepted but not written out yet,
+// in order, with their done callbacks.
+type sinkBuffer struct {
+	buf   bytes.Buffer
+	ends  []int // end of each event in buf
+	dones []func(ok bool)
+}
+
+func (b *sinkBuffer) add(value []byte, done func(ok bool)) {
+	b.buf.Write(value)
+	b.buf.WriteByte('\n')
+	b.ends = append(b.ends, b.buf.Len())
+	b.dones = append(b.dones, done)
+}
+
+func (b *sinkBuffer) count() int { return len(b.ends) }
+
+// written drops the first n bytes, which have been written out, and
+// confirms the events they complete.
+func (b *sinkBuffer) written(n int) {
+	b.buf.Next(n)
+	i := 0
+	for ; i < len(b.ends) && b.ends[i] <= n; i++ {
+		if b.dones[i] != nil {
+			b.dones[i](true)
+		}
+	}
+	b.ends = b.ends[i:]
+	b.dones = b.dones[i:]
+	for j := range b.ends {
+		b.ends[j] -= n
+	}
+}
+
+// drop discards the buffered events, reporting them as not written, and
+// returns how many there were.
+func (b *sinkBuffer) drop() int {
+	n := len(b.dones)
+	for _, done := range b.dones {
+		if done != nil {
+			done(false)
+		}
+	}
+	b.buf.Reset()
+	b.ends, b.dones = nil, nil
+	return n
+}
+
+// kafkaSink produces to the output topic. An event is done once its
+// delivery is reported.
+type kafkaSink struct {
+	producer *kafka.Producer
+	topic    string
+}
+
+func newKafkaSink(cfg *config.Config) (*kafkaSink, error) {
+	// Transactions need the Kafka source; see runTransactional.
+	c := *cfg
+	c.TransactionalID = ""
+	producer, err := kafka.NewProducer(ProducerConfig(&c))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create producer: %w", err)
+	}
+	go func() {
+		for e := range producer.Events() {
+			m, ok := e.(*kafka.Message)
+			if !ok {
+				continue
+			}
+			if m.TopicPartition.Error != nil {
+				log.Printf("❌ Delivery failed: %v", m.TopicPartition.Error)
+			}
+			if done, ok := m.Opaque.(func(bool)); ok {
+				done(m.TopicPartition.Error == nil)
+			}
+		}
+	}()
+	return &kafkaSink{producer: producer, topic: cfg.OutputTopic}, nil
+}
+
+func (s *kafkaSink) Write(ctx context.Context, value, key []byte, done func(ok bool)) error {
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &s.topic, Partition: kafka.PartitionAny},
+		Value:          value,
+		Key:            key,
+	}
+	if done != nil {
+		msg.Opaque = done
+	}
+	return produce(s.producer, msg)
+}
+
+// deadLetter sends value, which failed at stage, to topic; done reports
+// whether it was delivered.
+func (s *kafkaSink) deadLetter(topic string, value, key []byte, stage Stage, cause error, done func(ok bool)) error {
+	msg := deadLetter(topic, value, key, kafka.TopicPartition{}, stage, cause)
+	if done != nil {
+		msg.Opaque = done
+	}
+	return produce(s.producer, msg)
+}
+
+func (s *kafkaSink) Flush(timeout time.Duration) error {
+	if remaining := s.producer.Flush(int(timeout.Milliseconds())); remaining > 0 {
+		return fmt.Errorf("%d messages still queued after flush", remaining)
+	}
+	return nil
+}
+
+func (s *kafkaSink) Close() error {
+	s.producer.Close()
+	return nil
+}
+
+// writerSink writes one event per line to a file or stdout. Events are
+// done once they have been written; what a failed write leaves behind is
+// written again on the next flush.
+type writerSink struct {
+	mu      sync.Mutex
+	w       io.Writer
+	closer  io.Closer
+	pending sinkBuffer
+	stop    chan struct{}
+}
+
+// writerSinkBuffer is how many bytes a writerSink buffers before it writes
+// them out without waiting for the next flush.
+const writerSinkBuffer = 64 << 10
+
+func newFileSink(path string) (*writerSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return newWriterSink(f, f), nil
+}
+
+func newStdoutSink() *writerSink {
+	return newWriterSink(os.Stdout, nil)
+}
+
+func newWriterSink(w io.Writer, closer io.Closer) *writerSink {
+	s := &writerSink{w: w, closer: closer, stop: make(chan struct{})}
+	go flushEvery(sinkFlushInterval, s.stop, func() {
+		if err := s.Flush(0); err != nil {
+			log.Printf("❌ File sink: %v", err)
+		}
+	})
+	return s
+}
+
+func (s *writerSink) Write(ctx context.Context, value, key []byte, done func(ok bool)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := s.pending.count(); n >= sinkMaxPending {
+		return fmt.Errorf("%d events waiting for the output to recover", n)
+	}
+	s.pending.add(value, done)
+	if s.pending.buf.Len() >= writerSinkBuffer {
+		// A failure is retried by the next flush.
+		s.flush()
+	}
+	return nil
+}
+
+func (s *writerSink) Flush(timeout time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Pipes, such as a stdout nobody reads, support write deadlines;
+	// regular files do not block.
+	if d, ok := s.w.(interface{ SetWriteDeadline(time.Time) error }); ok && timeout > 0 {
+		if d.SetWriteDeadline(time.Now().Add(timeout)) == nil {
+			defer d.SetWriteDeadline(time.Time{})
+		}
+	}
+	return s.flush()
+}
+
+// flush writes out the pending events. The caller holds s.mu.
+func (s *writerSink) flush() error {
+	if s.pending.count() == 0 {
+		return nil
+	}
+	n, err := s.w.Write(s.pending.buf.Bytes())
+	s.pending.written(n)
+	if err != nil {
+		return fmt.Errorf("%d events not written: %w", s.pending.count(), err)
+	}
+	return nil
+}
+
+func (s *writerSink) Close() error {
+	close(s.stop)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.flush()
+	if n := s.pending.drop(); n > 0 {
+		log.Printf("⚠️ File sink closed with %d events not written: %v", n, err)
+	}
+	if s.closer != nil {
+		if cerr := s.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// httpSink posts events as NDJSON batches of up to httpSinkBatch events.
+// Events are done once the endpoint accepted their batch with a 2xx
+// status; a batch it does not accept is kept and posted again, at most
+// once per httpSinkRetry from Write and on every flush.
+type httpSink struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	pending sinkBuffer
+	retryAt time.Time
+	stop    chan struct{}
+}
+
+const (
+	httpSinkBatch = 500
+	httpSinkRetry = time.Second
+)
+
+func newHTTPSink(url string) *httpSink {
+	s := &httpSink{url: url, client: &http.Client{Timeout: 30 * time.Second}, stop: make(chan struct{})}
+	go flushEvery(sinkFlushInterval, s.stop, func() {
+		if err := s.Flush(0); err != nil {
+			log.Printf("❌ HTTP sink: %v", err)
+		}
+	})
+	return s
+}
+
+func (s *httpSink) Write(ctx context.Context, value, key []byte, done func(ok bool)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := s.pending.count(); n >= sinkMaxPending {
+		return fmt.Errorf("%d events waiting for %s to recover", n, s.url)
+	}
+	s.pending.add(value, done)
+	if s.pending.count() >= httpSinkBatch && time.Now().After(s.retryAt) {
+		// A failure is retried later; the events stay pending.
+		s.post(context.Background())
+	}
+	return nil
+}
+
+func (s *httpSink) Flush(timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.post(ctx)
+}
+
+// post sends the pending events in batches, oldest first, until one fails
+// or ctx is done. The caller holds s.mu.
+func (s *httpSink) post(ctx context.Context) error {
+	for s.pending.count() > 0 {
+		n := min(s.pending.count(), httpSinkBatch)
+		end := s.pending.ends[n-1]
+		if err := s.postBatch(ctx, s.pending.buf.Bytes()[:end]); err != nil {
+			s.retryAt = time.Now().Add(httpSinkRetry)
+			return fmt.Errorf("%d events not posted: %w", s.pending.count(), err)
+		}
+		s.pending.written(end)
+	}
+	return nil
+}
+
+func (s *httpSink) postBatch(ctx context.Context, batch []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(batch))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSink) Close() error {
+	close(s.stop)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.post(context.Background())
+	if n := s.pending.drop(); n > 0 {
+		log.Printf("⚠️ HTTP sink closed with %d events not posted: %v", n, err)
+	}
+	return err
+}
+
+// flushEvery calls flush every interval until stop is closed.
+func flushEvery(interval time.Duration, stop <-chan struct{}, flush func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package normalizer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// doneRecorder records the Done results of the events written to a sink.
+type doneRecorder struct {
+	mu      sync.Mutex
+	results map[int]bool
+}
+
+func (r *doneRecorder) done(i int) func(ok bool) {
+	return func(ok bool) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.results == nil {
+			r.results = map[int]bool{}
+		}
+		if _, twice := r.results[i]; twice {
+			panic("event done twice")
+		}
+		r.results[i] = ok
+	}
+}
+
+// count returns how many events are done, and how many of them ok.
+func (r *doneRecorder) count() (done, ok int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, result := range r.results {
+		if result {
+			ok++
+		}
+	}
+	return len(r.results), ok
+}
+
+func TestHTTPSink(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	var mu sync.Mutex
+	var batches []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/x-ndjson" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		batches = append(batches, bytes.Count(body, []byte("\n")))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	posted := func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), batches...)
+	}
+
+	s := newHTTPSink(srv.URL)
+	var events doneRecorder
+	write := func(from, to int) {
+		t.Helper()
+		for i := from; i < to; i++ {
+			if err := s.Write(context.Background(), []byte(`{"n":1}`), nil, events.done(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// A full batch is posted from Write, the rest on flush.
+	write(0, httpSinkBatch+2)
+	if got := posted(); len(got) != 1 || got[0] != httpSinkBatch {
+		t.Fatalf("posted batches %v, want one of %d", got, httpSinkBatch)
+	}
+	if done, ok := events.count(); done != httpSinkBatch || ok != done {
+		t.Fatalf("%d events done (%d ok) after the first batch", done, ok)
+	}
+	if err := s.Flush(0); err != nil {
+		t.Fatal(err)
+	}
+	if got := posted(); len(got) != 2 || got[1] != 2 {
+		t.Fatalf("posted batches %v, want the last 2 events flushed", got)
+	}
+
+	// A rejected batch is kept, not done, and posted again once the
+	// endpoint recovers.
+	healthy.Store(false)
+	write(httpSinkBatch+2, httpSinkBatch+5)
+	if err := s.Flush(0); err == nil {
+		t.Fatal("flush to a failing endpoint succeeded")
+	}
+	if done, _ := events.count(); done != httpSinkBatch+2 {
+		t.Fatalf("%d events done after a failed post, want %d", done, httpSinkBatch+2)
+	}
+	healthy.Store(true)
+	if err := s.Flush(0); err != nil {
+		t.Fatal(err)
+	}
+	if got := posted(); len(got) != 3 || got[2] != 3 {
+		t.Fatalf("posted batches %v, want the 3 kept events posted again", got)
+	}
+
+	// Events still pending when the sink closes are done, but not ok.
+	healthy.Store(false)
+	write(httpSinkBatch+5, httpSinkBatch+7)
+	if err := s.Close(); err == nil {
+		t.Error("close with unposted events succeeded")
+	}
+	if done, ok := events.count(); done != httpSinkBatch+7 || ok != httpSinkBatch+5 {
+		t.Errorf("%d events done, %d ok after close, want %d and %d", done, ok, httpSinkBatch+7, httpSinkBatch+5)
+	}
+}
+
+func TestHTTPSinkRetryDelay(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	s := newHTTPSink(srv.URL)
+	defer s.Close()
+
+	// After a failed post, Write waits httpSinkRetry before posting again.
+	for range httpSinkBatch + 10 {
+		s.Write(context.Background(), []byte(`{}`), nil, nil)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("%d posts, want 1 until the retry delay passed", got)
+	}
+}
+
+func TestHTTPSinkFlushTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	s := newHTTPSink(srv.URL)
+	defer s.Close()
+	defer close(release)
+
+	var events doneRecorder
+	for i := range 3 {
+		s.Write(context.Background(), []byte(`{}`), nil, events.done(i))
+	}
+	start := time.Now()
+	err := s.Flush(100 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("flush took %s with a timeout of 100ms", elapsed)
+	}
+	if err == nil || !strings.Contains(err.Error(), "3 events not posted") {
+		t.Errorf("flush error %v, want the 3 unsent events", err)
+	}
+	if done, _ := events.count(); done != 0 {
+		t.Errorf("%d events done after a timed out flush", done)
+	}
+}
+
+// stuckWriter blocks every write until its write deadline passes, and
+// fails writes without one.
+type stuckWriter struct {
+	deadline time.Time
+}
+
+func (w *stuckWriter) SetWriteDeadline(t time.Time) error {
+	w.deadline = t
+	return nil
+}
+
+func (w *stuckWriter) Write(p []byte) (int, error) {
+	if w.deadline.IsZero() {
+		return 0, errors.New("stuck")
+	}
+	time.Sleep(time.Until(w.deadline))
+	return 0, os.ErrDeadlineExceeded
+}
+
+func TestWriterSinkFlushTimeout(t *testing.T) {
+	w := &stuckWriter{}
+	s := newWriterSink(w, nil)
+	for range 2 {
+		s.Write(context.Background(), []byte(`{}`), nil, nil)
+	}
+	err := s.Flush(50 * time.Millisecond)
+	if !errors.Is(err, os.ErrDeadlineExceeded) || !strings.Contains(err.Error(), "2 events not written") {
+		t.Errorf("flush error %v, want the 2 unsent events", err)
+	}
+	if !w.deadline.IsZero() {
+		t.Error("write deadline not cleared after the flush")
+	}
+	s.Close()
+}
+
+// flakyWriter accepts limit bytes, then fails every write once.
+type flakyWriter struct {
+	bytes.Buffer
+	limit int
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	if n := w.limit - w.Len(); n < len(p) {
+		w.Buffer.Write(p[:max(n, 0)])
+		w.limit = 1 << 20
+		return max(n, 0), errors.New("disk full")
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestWriterSink(t *testing.T) {
+	w := &flakyWriter{limit: 12}
+	s := newWriterSink(w, nil)
+	var events doneRecorder
+	for i, value := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`} {
+		s.Write(context.Background(), []byte(value), nil, events.done(i))
+	}
+	if done, _ := events.count(); done != 0 {
+		t.Fatalf("%d events done before the flush", done)
+	}
+
+	// A failed write confirms the events it completed and keeps the rest.
+	if err := s.Flush(time.Second); err == nil {
+		t.Fatal("failed write not reported")
+	}
+	if done, ok := events.count(); done != 1 || ok != 1 {
+		t.Fatalf("%d events done (%d ok) after a partial write, want 1", done, ok)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if done, ok := events.count(); done != 3 || ok != 3 {
+		t.Errorf("%d events done (%d ok) after close, want 3", done, ok)
+	}
+	if w.String() != "{\"n\":1}\n{\"n\":2}\n{\"n\":3}\n" {
+		t.Errorf("wrote %q", w.String())
+	}
+}
@@ -0,0 +1,200 @@
+package normalizer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/izzatbey/soc-norm-events/internal/config"
+)
+
+// tailInterval is how often a followed file is checked for new lines,
+// rotation and truncation once its end has been reached.
+const tailInterval = 250 * time.Millisecond
+
+// kafkaSource consumes the input topic. An event's offset is stored for the
+// next automatic commit once it has been written to the sink; an event that
+// could not be written is read again.
+type kafkaSource struct {
+	consumer *kafka.Consumer
+	topic    string
+}
+
+func newKafkaSource(cfg *config.Config) (*kafkaSource, error) {
+	cc := ConsumerConfig(cfg)
+	_ = cc.SetKey("enable.auto.commit", true)
+	_ = cc.SetKey("enable.auto.offset.store", false)
+	consumer, err := kafka.NewConsumer(cc)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaSource{consumer: consumer, topic: cfg.InputTopic}, nil
+}
+
+func (s *kafkaSource) Events(ctx context.Context, handle func(Event)) error {
+	if err := s.consumer.Subscribe(s.topic, nil); err != nil {
+		return fmt.Errorf("failed to subscribe: %w", err)
+	}
+	for ctx.Err() == nil {
+		msg, err := s.consumer.ReadMessage(100 * time.Millisecond)
+		if err != nil {
+			if kerr, ok := err.(kafka.Error); ok && kerr.IsTimeout() {
+				continue
+			}
+			log.Printf("Consumer Error: %v", err)
+			continue
+		}
+		handle(Event{Value: msg.Value, Key: msg.Key, Done: func(ok bool) {
+			if ok {
+				if _, err := s.consumer.StoreMessage(msg); err != nil {
+					log.Printf("⚠️ Storing offset %v failed: %v", msg.TopicPartition, err)
+				}
+				return
+			}
+			// Read the event again rather than skip it.
+			if err := s.consumer.Seek(msg.TopicPartition, 0); err != nil {
+				log.Printf("⚠️ Seek to %v failed: %v", msg.TopicPartition, err)
+			}
+		}})
+	}
+	return nil
+}
+
+func (s *kafkaSource) Close() error {
+	return s.consumer.Close()
+}
+
+// fileSource reads one JSON event per line from a file or stdin. A followed
+// file is tailed: at its end the source waits for more lines, and reopens
+// the path when the file is rotated or truncated.
+type fileSource struct {
+	path   string
+	follow bool
+	f      *os.File
+}
+
+func newFileSource(path string, follow bool) (*fileSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSource{path: path, follow: follow, f: f}, nil
+}
+
+func newStdinSource() *fileSource {
+	return &fileSource{path: "", f: os.Stdin}
+}
+
+func (s *fileSource) Events(ctx context.Context, handle func(Event)) error {
+	r := bufio.NewReaderSize(s.f, 64*1024)
+	var line []byte
+	var offset int64
+	// next is the file now at the path once the followed one was rotated.
+	var next *os.File
+	defer func() {
+		if next != nil {
+			next.Close()
+		}
+	}()
+	for ctx.Err() == nil {
+		chunk, err := r.ReadBytes('\n')
+		offset += int64(len(chunk))
+		line = append(line, chunk...)
+		if err == nil {
+			emitLine(line, handle)
+			line = line[:0]
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+		if !s.follow {
+			emitLine(line, handle)
+			return nil
+		}
+		if next != nil {
+			// The rotated file has been read to its end, including what was
+			// written to it just before the rename; its last line is
+			// complete even without a newline.
+			emitLine(line, handle)
+			line = line[:0]
+			s.f.Close()
+			s.f, next = next, nil
+			r.Reset(s.f)
+			offset = 0
+			log.Printf("🔁 %s was rotated, reading the new file", s.path)
+			continue
+		}
+
+		// Keep a partial last line until the writer completes it.
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(tailInterval):
+		}
+		var truncated bool
+		next, truncated, err = s.reopen(offset)
+		if err != nil {
+			return err
+		}
+		if truncated {
+			r.Reset(s.f)
+			line = line[:0]
+			offset = 0
+		}
+	}
+	return nil
+}
+
+// reopen returns the file now at the path if the followed file was rotated
+// away, to be read once the rotated one is drained, or rewinds the followed
+// file and reports whether it was truncated below offset.
+func (s *fileSource) reopen(offset int64) (next *os.File, truncated bool, err error) {
+	current, err := s.f.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+	latest, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		// Rotated, and the new file is not there yet.
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !os.SameFile(current, latest) {
+		next, err := os.Open(s.path)
+		return next, false, err
+	}
+	if latest.Size() < offset {
+		if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+			return nil, false, err
+		}
+		log.Printf("🔁 %s was truncated, reading from the start", s.path)
+		return nil, true, nil
+	}
+	return nil, false, nil
+}
+
+func emitLine(line []byte, handle func(Event)) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+	handle(Event{Value: bytes.Clone(line)})
+}
+
+func (s *fileSource) Close() error {
+	if s.f == os.Stdin {
+		return nil
+	}
+	return s.f.Close()
+}
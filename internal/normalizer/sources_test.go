@@ -0,0 +1,102 @@
+package normalizer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	os.WriteFile(path, []byte("{\"n\":1}\n\n  {\"n\":2}  \n{\"n\":3}"), 0o644)
+
+	s, err := newFileSource(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var got []string
+	if err := s.Events(context.Background(), func(ev Event) { got = append(got, string(ev.Value)) }); err != nil {
+		t.Fatal(err)
+	}
+	// Blank lines are skipped, and the unterminated last line is read.
+	if strings.Join(got, " ") != `{"n":1} {"n":2} {"n":3}` {
+		t.Errorf("read %q", got)
+	}
+
+	if _, err := newFileSource(filepath.Join(t.TempDir(), "missing"), false); err == nil {
+		t.Error("missing file opened")
+	}
+}
+
+func TestFileSourceFollow(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events.ndjson")
+	write := func(flag int, data string) {
+		t.Helper()
+		f, err := os.OpenFile(path, flag|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(data)
+		f.Close()
+	}
+	write(os.O_TRUNC, `{"n":1}`+"\n"+`{"n":`)
+
+	s, err := newFileSource(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan string, 10)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- s.Events(ctx, func(ev Event) { events <- string(ev.Value) })
+	}()
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case got := <-events:
+			if got != want {
+				t.Errorf("read %s, want %s", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s not read", want)
+		}
+	}
+
+	// A partial line is kept until the writer completes it.
+	expect(`{"n":1}`)
+	time.Sleep(2 * tailInterval)
+	write(os.O_APPEND, "2}\n")
+	expect(`{"n":2}`)
+
+	// A rotated file is read to its end, including what was written to it
+	// right before the rename and its unterminated last line, then the new
+	// one at the path is followed.
+	write(os.O_APPEND, `{"n":3}`+"\n"+`{"n":4}`)
+	os.Rename(path, path+".1")
+	expect(`{"n":3}`)
+	time.Sleep(2 * tailInterval)
+	write(os.O_TRUNC, `{"n":5,"padding":"long enough to be cut by the truncation"}`+"\n")
+	expect(`{"n":4}`)
+	expect(`{"n":5,"padding":"long enough to be cut by the truncation"}`)
+
+	// A truncated file is read again from the start.
+	write(os.O_TRUNC, `{"n":6}`+"\n")
+	expect(`{"n":6}`)
+
+	cancel()
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("unexpected event %s", ev)
+	default:
+	}
+}
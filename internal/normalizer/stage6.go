@@ -1,120 +1,526 @@
 package normalizer
 
 import (
-	"io/ioutil"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/izzatbey/soc-norm-events/internal/config"
 	"github.com/tidwall/gjson"
-	"github.com/tidwall/sjson"
 )
 
-func ApplyStage6Rules(raw string) string {
-	raw = enrichWithMISP(raw)
-	raw = enrichWithEPSS(raw)
-	return raw
+// enricher is the stage-6 enrichment run after the rules: IOC fields
+// matched against local threat-intel feeds and looked up in MISP, and EPSS
+// scores of CVEs. The lookups of an event are sent to each provider in
+// batches, at most cap(sem) requests across all events at once, and share
+// one HTTP client, a result cache and in-flight requests for the same
+// indicator. An event waits for its lookups no longer than
+// budget; those still running finish in the background and fill the cache
+// for later events.
+type enricher struct {
+	// categories are the source categories to enrich; nil enriches all.
+	categories []string
+
+	client  *http.Client
+	sem     chan struct{}
+	cache   *lookupCache
+	flights flightGroup
+	budget  time.Duration
+
+	// iocFields are matched against feeds and looked up in misp; feeds,
+	// misp and epss are nil when not configured.
+	iocFields []string
+	feeds     *feedSet
+	misp      *provider
+	mispURL   string
+	mispKey   string
+
+	epss    *provider
+	epssURL string
+}
+
+// provider is one enrichment source with its own timeout, batch size,
+// circuit breaker and counters.
+type provider struct {
+	name      string
+	timeout   time.Duration
+	batchSize int
+	breaker   *breaker
+
+	// fetch looks up values in one request; values missing from the result
+	// are unknown to the provider. apply adds the result for value, found
+	// in field, to the event.
+	fetch func(ctx context.Context, values []string) (map[string]lookupResult, error)
+	apply func(doc *Document, field, value string, result lookupResult)
+
+	succeeded atomic.Uint64
+	failed    atomic.Uint64
+	skipped   atomic.Uint64
+	timedOut  atomic.Uint64
+	cacheHits atomic.Uint64
 }
 
-func enrichWithMISP(raw string) string {
-	mispURL := "https://10.80.120.12/attributes/restSearch/value:"
-	apiKey := "54dnrnEFXv94QnLjhgnM6eHUHY0u6xRS05HOqH9n"
+// Lookup outcomes recorded per provider in enrichment.status. A provider
+// is skipped while its circuit breaker is open; when an event's lookups
+// differ, the status is the worst of them.
+const (
+	EnrichSucceeded = "succeeded"
+	EnrichSkipped   = "skipped"
+	EnrichTimedOut  = "timed_out"
+	EnrichFailed    = "failed"
+)
 
-	client := &http.Client{Timeout: 3 * time.Second}
+var enrichStatusRank = []string{EnrichSucceeded, EnrichSkipped, EnrichTimedOut, EnrichFailed}
 
-	iocFields := []string{
-		"source.ip",
-		"destination.ip",
-		"file.hash.md5",
-		"file.hash.sha1",
-		"file.hash.sha256",
-		"process.hash.sha256",
-		"dns.question.name",
+// lookup is one indicator of an event to look up.
+type lookup struct {
+	provider *provider
+	field    string
+	value    string
+}
+
+func (l lookup) key() string {
+	return l.provider.name + "|" + l.value
+}
+
+var activeEnricher atomic.Pointer[enricher]
+
+// maxLookupTimeout bounds a lookup whose provider has no timeout, or a
+// longer one, from waiting for a slot to its answer.
+const maxLookupTimeout = 30 * time.Second
+
+// ConfigureEnrichment enables stage-6 enrichment as configured by cfg, or
+// disables it unless cfg.Enrich is set. It must run after ConfigureRules,
+// whose ruleset the enabled categories are checked against.
+func ConfigureEnrichment(cfg *config.Config) error {
+	if !cfg.Enrich {
+		activeEnricher.Store(nil)
+		return nil
 	}
 
-	for _, field := range iocFields {
-		value := gjson.Get(raw, field).String()
-		if value == "" || isPrivateIP(value) || strings.EqualFold(value, "127.0.0.1") {
-			continue
+	concurrency := max(cfg.EnrichConcurrency, 1)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = concurrency
+	e := &enricher{
+		categories: splitList(cfg.EnrichCategories),
+		client:     &http.Client{Transport: transport, Timeout: maxLookupTimeout},
+		sem:        make(chan struct{}, concurrency),
+		cache:      newLookupCache(cfg.EnrichCacheSize, cfg.EnrichCacheTTL, cfg.EnrichNegativeTTL),
+		budget:     cfg.EnrichBudget,
+		iocFields:  splitList(cfg.MISPIOCFields),
+	}
+	known := ActiveRuleset().Categories()
+	for _, c := range e.categories {
+		if !slices.Contains(known, c) {
+			return fmt.Errorf("ENRICH_CATEGORIES: unknown source category %q (ruleset has %s)", c, strings.Join(known, ", "))
+		}
+	}
+	newProvider := func(name string, timeout time.Duration, batchSize int) *provider {
+		return &provider{
+			name:      name,
+			timeout:   cmp.Or(min(timeout, maxLookupTimeout), maxLookupTimeout),
+			batchSize: max(batchSize, 1),
+			breaker:   newBreaker(name, cfg.EnrichBreakerFailures, cfg.EnrichBreakerCooldown),
 		}
+	}
 
-		req, err := http.NewRequest("GET", mispURL+value, nil)
+	if cfg.EnrichFeeds != "" {
+		feeds, err := newFeedSet(cfg.EnrichFeeds)
+		if err != nil {
+			return err
+		}
+		e.feeds = feeds
+	}
+	if cfg.MISPURL != "" {
+		key, err := mispKey(cfg)
 		if err != nil {
+			return err
+		}
+		if cfg.MISPCAFile != "" {
+			pool, err := caPool(cfg.MISPCAFile)
+			if err != nil {
+				return err
+			}
+			transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		}
+		e.misp = newProvider("misp", cfg.MISPTimeout, cfg.MISPBatchSize)
+		e.misp.fetch = e.fetchMISP
+		e.misp.apply = applyMISP
+		e.mispURL = strings.TrimSuffix(cfg.MISPURL, "/")
+		e.mispKey = key
+	}
+	if cfg.EPSSURL != "" {
+		e.epss = newProvider("epss", cfg.EPSSTimeout, epssBatchSize)
+		e.epss.fetch = e.fetchEPSS
+		e.epss.apply = applyEPSS
+		e.epssURL = cfg.EPSSURL
+	}
+
+	activeEnricher.Store(e)
+	log.Printf("🔎 Stage-6 enrichment enabled (feeds=%t, misp=%t, epss=%t, categories=%s, budget=%s)",
+		e.feeds != nil, e.misp != nil, e.epss != nil, cmp.Or(cfg.EnrichCategories, "all"), e.budget)
+	return nil
+}
+
+// mispKey returns the MISP API key from cfg or from its secrets file.
+func mispKey(cfg *config.Config) (string, error) {
+	if cfg.MISPAPIKeyFile == "" {
+		if cfg.MISPAPIKey == "" {
+			return "", errors.New("MISP_URL needs MISP_API_KEY or MISP_API_KEY_FILE")
+		}
+		return cfg.MISPAPIKey, nil
+	}
+	b, err := os.ReadFile(cfg.MISPAPIKeyFile)
+	if err != nil {
+		return "", fmt.Errorf("read MISP API key: %w", err)
+	}
+	key := strings.TrimSpace(string(b))
+	if key == "" {
+		return "", fmt.Errorf("MISP API key file %s is empty", cfg.MISPAPIKeyFile)
+	}
+	return key, nil
+}
+
+// caPool returns the system CAs plus those of the PEM bundle at path.
+func caPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read CA bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in CA bundle %s", path)
+	}
+	return pool, nil
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// enrich applies stage-6 enrichment to an event of category, if it is
+// enabled for that category, and records in enrichment.status how each
+// provider's lookups went. Lookups that miss the budget are left out and
+// the event is marked with enrichment.budget_exceeded.
+func (e *enricher) enrich(doc *Document, category string) {
+	if e == nil || (e.categories != nil && !slices.Contains(e.categories, category)) {
+		return
+	}
+	e.matchFeeds(doc)
+	lookups := e.lookups(doc)
+	if len(lookups) == 0 {
+		return
+	}
+
+	outcomes := make([]*outcome, len(lookups))
+	pending := make(chan *outcome, len(lookups))
+	batches := map[*provider][]int{}
+	waiting := 0
+	for i, l := range lookups {
+		if result, ok := e.cache.get(l.key()); ok {
+			l.provider.cacheHits.Add(1)
+			outcomes[i] = &outcome{i: i, result: result}
 			continue
 		}
-		req.Header.Set("Accept", "application/json")
-		req.Header.Set("Authorization", apiKey)
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("User-Agent", "Go-Normalizer-MISP-Lookup")
+		batches[l.provider] = append(batches[l.provider], i)
+		waiting++
+	}
+	for p, indexes := range batches {
+		go e.resolve(p, lookups, indexes, pending)
+	}
 
-		resp, err := client.Do(req)
-		if err != nil {
+	// A zero budget waits for every lookup, each bounded by its timeout.
+	var budget <-chan time.Time
+	if e.budget > 0 && waiting > 0 {
+		timer := time.NewTimer(e.budget)
+		defer timer.Stop()
+		budget = timer.C
+	}
+	exceeded := false
+wait:
+	for ; waiting > 0; waiting-- {
+		select {
+		case o := <-pending:
+			outcomes[o.i] = o
+		case <-budget:
+			exceeded = true
+			break wait
+		}
+	}
+
+	// Apply in lookup order so that the output does not depend on timing.
+	var providers []*provider
+	status := map[*provider]string{}
+	for i, o := range outcomes {
+		l := lookups[i]
+		s := EnrichSucceeded
+		switch {
+		case o == nil:
+			s = EnrichTimedOut
+			l.provider.timedOut.Add(1)
+		case errors.Is(o.err, errBreakerOpen):
+			s = EnrichSkipped
+		case errors.Is(o.err, context.DeadlineExceeded):
+			s = EnrichTimedOut
+		case o.err != nil:
+			s = EnrichFailed
+		default:
+			l.provider.apply(doc, l.field, l.value, o.result)
+		}
+		prev, seen := status[l.provider]
+		if !seen {
+			providers = append(providers, l.provider)
+		}
+		if !seen || slices.Index(enrichStatusRank, s) > slices.Index(enrichStatusRank, prev) {
+			status[l.provider] = s
+		}
+	}
+	for _, p := range providers {
+		doc.Set("enrichment.status."+p.name, status[p])
+	}
+	if exceeded {
+		doc.Set("enrichment.budget_exceeded", true)
+	}
+}
+
+// outcome is the result of lookup i of an event.
+type outcome struct {
+	i      int
+	result lookupResult
+	err    error
+}
+
+// lookups returns the lookups for the indicators of doc.
+func (e *enricher) lookups(doc *Document) []lookup {
+	var lookups []lookup
+	if e.misp != nil {
+		for _, field := range e.iocFields {
+			value := doc.GetString(field)
+			if value == "" || isPrivateIP(value) {
+				continue
+			}
+			lookups = append(lookups, lookup{provider: e.misp, field: field, value: value})
+		}
+	}
+	if e.epss != nil {
+		if cve := doc.GetString("data.vulnerability.cve"); cve != "" {
+			lookups = append(lookups, lookup{provider: e.epss, field: "data.vulnerability.cve", value: cve})
+		}
+	}
+	return lookups
+}
+
+// resolve looks up lookups[i] for every i of indexes, all of provider p,
+// and sends their outcomes to pending. An indicator already being looked
+// up for another event is waited for instead; the others are fetched in
+// batches of p.batchSize. Results, including unknown indicators, are
+// cached; failures are not. While p's circuit breaker is open, lookups
+// fail with errBreakerOpen.
+func (e *enricher) resolve(p *provider, lookups []lookup, indexes []int, pending chan<- *outcome) {
+	type lead struct {
+		i int
+		f *flight
+	}
+	var leads []lead
+	for _, i := range indexes {
+		key := lookups[i].key()
+		f, leader := e.flights.start(key)
+		if !leader {
+			go func() {
+				<-f.done
+				pending <- &outcome{i: i, result: f.result, err: f.err}
+			}()
+			continue
+		}
+		// The lookup may have finished since the cache was checked.
+		if result, ok := e.cache.get(key); ok {
+			p.cacheHits.Add(1)
+			e.flights.finish(key, f, result, nil)
+			pending <- &outcome{i: i, result: result}
 			continue
 		}
-		defer resp.Body.Close()
+		leads = append(leads, lead{i, f})
+	}
 
-		if resp.StatusCode == 200 {
-			body, _ := ioutil.ReadAll(resp.Body)
-			category := gjson.GetBytes(body, "response.Attribute[0].category").String()
-			if category != "" {
-				raw, _ = sjson.Set(raw, "misp.category", category)
+	for batch := range slices.Chunk(leads, p.batchSize) {
+		values := make([]string, len(batch))
+		for j, l := range batch {
+			values[j] = lookups[l.i].value
+		}
+		results, err := e.fetchBatch(p, values)
+		for _, l := range batch {
+			key := lookups[l.i].key()
+			result := results[lookups[l.i].value]
+			if err == nil {
+				e.cache.add(key, result)
 			}
+			e.flights.finish(key, l.f, result, err)
+			pending <- &outcome{i: l.i, result: result, err: err}
 		}
 	}
-	return raw
 }
 
-func enrichWithEPSS(raw string) string {
-	cve := gjson.Get(raw, "data.vulnerability.cve").String()
-	if cve == "" {
-		return raw
+// fetchBatch looks values up with p in one request, unless its circuit
+// breaker is open. p.timeout covers the wait for a slot as well as the
+// request, so a slow provider cannot pile up lookups waiting for slots.
+func (e *enricher) fetchBatch(p *provider, values []string) (map[string]lookupResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	select {
+	case e.sem <- struct{}{}:
+		defer func() { <-e.sem }()
+	case <-ctx.Done():
+		p.timedOut.Add(uint64(len(values)))
+		return nil, ctx.Err()
+	}
+	if !p.breaker.allow() {
+		p.skipped.Add(uint64(len(values)))
+		return nil, errBreakerOpen
+	}
+
+	results, err := p.fetch(ctx, values)
+	p.breaker.record(err)
+	if err != nil {
+		p.failed.Add(uint64(len(values)))
+		return nil, err
+	}
+	p.succeeded.Add(uint64(len(values)))
+	return results, nil
+}
+
+// EnrichmentStats returns the counters of every configured provider, or
+// nil when enrichment is disabled.
+func EnrichmentStats() []ProviderStats {
+	e := activeEnricher.Load()
+	if e == nil {
+		return nil
 	}
+	var stats []ProviderStats
+	for _, p := range []*provider{e.misp, e.epss} {
+		if p == nil {
+			continue
+		}
+		state, opened := p.breaker.status()
+		stats = append(stats, ProviderStats{
+			Provider:      p.name,
+			Breaker:       state,
+			BreakerOpened: opened,
+			Succeeded:     p.succeeded.Load(),
+			Failed:        p.failed.Load(),
+			Skipped:       p.skipped.Load(),
+			TimedOut:      p.timedOut.Load(),
+			CacheHits:     p.cacheHits.Load(),
+		})
+	}
+	return stats
+}
+
+// ProviderStats counts an enrichment provider's lookups since start-up.
+type ProviderStats struct {
+	Provider      string
+	Breaker       string
+	BreakerOpened uint64
+	Succeeded     uint64
+	Failed        uint64
+	Skipped       uint64
+	TimedOut      uint64
+	CacheHits     uint64
+}
+
+// epssBatchSize is the number of CVEs asked for per EPSS request.
+const epssBatchSize = 100
+
+// epssScore is the EPSS answer for one CVE.
+type epssScore struct {
+	status, score, percentile string
+}
 
-	url := "https://api.first.org/data/v1/epss?cve=" + cve
-	client := &http.Client{Timeout: 3 * time.Second}
-	req, err := http.NewRequest("GET", url, nil)
+func (e *enricher) fetchEPSS(ctx context.Context, cves []string) (map[string]lookupResult, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", e.epssURL+"?cve="+url.QueryEscape(strings.Join(cves, ",")), nil)
 	if err != nil {
-		return raw
+		return nil, err
 	}
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("User-Agent", "Go-Normalizer-EPSS-Lookup")
 
-	resp, err := client.Do(req)
+	body, err := fetch(e.client, req)
 	if err != nil {
-		return raw
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 200 {
-		body, _ := ioutil.ReadAll(resp.Body)
-		status := gjson.GetBytes(body, "status").String()
-		score := gjson.GetBytes(body, "data.0.epss").String()
-		severity := gjson.GetBytes(body, "data.0.percentile").String()
-
-		if status != "" {
-			raw, _ = sjson.Set(raw, "epss.status", status)
-		}
-		if score != "" {
-			raw, _ = sjson.Set(raw, "epss.score", score)
+	status := gjson.GetBytes(body, "status").String()
+	results := make(map[string]lookupResult, len(cves))
+	for _, cve := range cves {
+		results[cve] = lookupResult{Value: epssScore{status: status}}
+	}
+	for _, data := range gjson.GetBytes(body, "data").Array() {
+		cve := data.Get("cve").String()
+		if _, ok := results[cve]; ok {
+			results[cve] = lookupResult{Value: epssScore{
+				status:     status,
+				score:      data.Get("epss").String(),
+				percentile: data.Get("percentile").String(),
+			}, Found: true}
 		}
-		if severity != "" {
-			raw, _ = sjson.Set(raw, "epss.percentile", severity)
+	}
+	return results, nil
+}
+
+func applyEPSS(doc *Document, field, cve string, result lookupResult) {
+	score, _ := result.Value.(epssScore)
+	for _, f := range []struct{ field, value string }{
+		{"epss.status", score.status},
+		{"epss.score", score.score},
+		{"epss.percentile", score.percentile},
+	} {
+		if f.value != "" {
+			doc.Set(f.field, f.value)
 		}
 	}
-	return raw
 }
 
-func isPrivateIP(ip string) bool {
-	privateCIDRs := []string{
-		"10.",
-		"192.168.",
-		"172.16.",
-		"127.",
+// fetch sends req and returns the body of a 200 response.
+func fetch(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
 	}
-	for _, prefix := range privateCIDRs {
-		if strings.HasPrefix(ip, prefix) {
-			return true
-		}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("%s: %s", req.URL.Host, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// isPrivateIP reports whether value is an address not worth looking up:
+// private, loopback, link-local or unspecified.
+func isPrivateIP(value string) bool {
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return false
 	}
-	return false
+	return addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified()
 }
@@ -0,0 +1,347 @@
+package normalizer
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/izzatbey/soc-norm-events/internal/config"
+)
+
+// newMISP serves MISP attribute searches after delay, answering
+// "203.0.113.7" as malicious, and records the values of every request.
+func newMISP(t *testing.T, key string, delay time.Duration) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/attributes/restSearch" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Authorization") != key {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		time.Sleep(delay)
+		var query struct{ Value []string }
+		json.NewDecoder(r.Body).Decode(&query)
+		mu.Lock()
+		requests = append(requests, strings.Join(query.Value, ","))
+		mu.Unlock()
+		if slices.Contains(query.Value, "203.0.113.7") {
+			w.Write([]byte(mispHit))
+			return
+		}
+		w.Write([]byte(`{"response":{"Attribute":[]}}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requests...)
+	}
+}
+
+const mispHit = `{"response":{"Attribute":[{
+	"id":"1001","event_id":"42","type":"ip-dst|port","category":"Network activity",
+	"value":"203.0.113.7|443","to_ids":true,"first_seen":"2025-01-01T00:00:00.000000+00:00","last_seen":null,
+	"Event":{"id":"42","info":"APT28 C2 infrastructure"},
+	"Tag":[{"name":"tlp:amber"},{"name":"misp-galaxy:threat-actor=\"APT28\""}]
+}]}}`
+
+func TestEnrichment(t *testing.T) {
+	misp, requested := newMISP(t, "secret", 0)
+	epss := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"OK","data":[{"cve":"` + r.URL.Query().Get("cve") + `","epss":"0.97","percentile":"0.99"}]}`))
+	}))
+	t.Cleanup(epss.Close)
+
+	keyFile := filepath.Join(t.TempDir(), "misp_api_key")
+	os.WriteFile(keyFile, []byte("secret\n"), 0o600)
+	cfg := &config.Config{
+		Enrich:           true,
+		EnrichCategories: "fortigate",
+		MISPURL:          misp.URL + "/",
+		MISPAPIKeyFile:   keyFile,
+		MISPIOCFields:    "source.ip, destination.ip",
+		EPSSURL:          epss.URL,
+	}
+	if err := ConfigureEnrichment(cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { activeEnricher.Store(nil) })
+
+	event := `{"decoder":{"name":"fortigate"},"data":{"srcip":"10.0.0.1","dstip":"203.0.113.7","vulnerability":{"cve":"CVE-2024-3400"}}}`
+	out, err := Normalize(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, _ := ParseDocument(out)
+	for field, want := range map[string]string{
+		"threat.enrichments.0.matched.field":           "destination.ip",
+		"threat.enrichments.0.matched.atomic":          "203.0.113.7",
+		"threat.enrichments.0.indicator.type":          "ip-dst|port",
+		"threat.enrichments.0.indicator.first_seen":    "2025-01-01T00:00:00.000000+00:00",
+		"threat.enrichments.0.indicator.marking.tlp":   "AMBER",
+		"threat.enrichments.0.misp.event.id":           "42",
+		"threat.enrichments.0.misp.event.info":         "APT28 C2 infrastructure",
+		"threat.enrichments.0.misp.attribute.category": "Network activity",
+		"threat.enrichments.0.misp.attribute.to_ids":   "true",
+		"threat.enrichments.0.misp.galaxies.0":         `threat-actor="APT28"`,
+		"threat.enrichments.0.misp.tags.0":             "tlp:amber",
+		"misp.category":                                "Network activity",
+		"epss.status":                                  "OK",
+		"epss.score":                                   "0.97",
+		"epss.percentile":                              "0.99",
+
+		"enrichment.status.misp": EnrichSucceeded,
+		"enrichment.status.epss": EnrichSucceeded,
+	} {
+		if got := doc.GetString(field); got != want {
+			t.Errorf("%s = %q, want %q", field, got, want)
+		}
+	}
+	if got := requested(); len(got) != 1 || got[0] != "203.0.113.7" {
+		t.Errorf("looked up %q, want only the public destination.ip", got)
+	}
+
+	// Enrichment is off for categories not listed.
+	out, err = NormalizeAs(event, "hostname")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc, _ := ParseDocument(out); doc.Exists("threat") || doc.Exists("epss") {
+		t.Errorf("hostname event was enriched: %s", out)
+	}
+}
+
+func TestConfigureEnrichmentErrors(t *testing.T) {
+	t.Cleanup(func() { activeEnricher.Store(nil) })
+	for name, cfg := range map[string]*config.Config{
+		"unknown category": {Enrich: true, EnrichCategories: "fortigate,nope"},
+		"no api key":       {Enrich: true, MISPURL: "https://misp.example"},
+		"missing key file": {Enrich: true, MISPURL: "https://misp.example", MISPAPIKeyFile: "/nonexistent"},
+		"invalid ca file":  {Enrich: true, MISPURL: "https://misp.example", MISPAPIKey: "k", MISPCAFile: "stage6_test.go"},
+		"bad feed pattern": {Enrich: true, EnrichFeeds: "intel=feeds/[.json"},
+	} {
+		if err := ConfigureEnrichment(cfg); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+// configureMISP enables MISP enrichment of source.ip against misp.
+func configureMISP(t *testing.T, misp *httptest.Server, budget time.Duration) {
+	configure(t, &config.Config{
+		MISPURL:      misp.URL,
+		EnrichBudget: budget,
+	})
+}
+
+// configure enables enrichment with cfg, filled in with test defaults.
+func configure(t *testing.T, cfg *config.Config) {
+	err := ConfigureEnrichment(&config.Config{
+		Enrich:            true,
+		MISPURL:           cfg.MISPURL,
+		MISPAPIKey:        "secret",
+		MISPIOCFields:     cmp.Or(cfg.MISPIOCFields, "source.ip"),
+		MISPBatchSize:     cmp.Or(cfg.MISPBatchSize, 50),
+		MISPTimeout:       cfg.MISPTimeout,
+		EnrichConcurrency: cmp.Or(cfg.EnrichConcurrency, 4),
+		EnrichBudget:      cfg.EnrichBudget,
+		EnrichCacheSize:   100,
+		EnrichCacheTTL:    time.Hour,
+		EnrichNegativeTTL: time.Hour,
+
+		EnrichBreakerFailures: cfg.EnrichBreakerFailures,
+		EnrichBreakerCooldown: cfg.EnrichBreakerCooldown,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { activeEnricher.Store(nil) })
+}
+
+func ipEvent(ip string) string {
+	return `{"decoder":{"name":"fortigate"},"data":{"srcip":"` + ip + `"}}`
+}
+
+func TestEnrichmentCacheAndCoalescing(t *testing.T) {
+	misp, requested := newMISP(t, "secret", 50*time.Millisecond)
+	configureMISP(t, misp, 0)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, _ := Normalize(ipEvent("203.0.113.7"))
+			if doc, _ := ParseDocument(out); doc.GetString("threat.enrichments.0.misp.event.id") != "42" {
+				t.Errorf("not enriched: %s", out)
+			}
+		}()
+	}
+	wg.Wait()
+	// A known and an unknown indicator, both cached now or after this.
+	Normalize(ipEvent("203.0.113.7"))
+	Normalize(ipEvent("198.51.100.1"))
+	Normalize(ipEvent("198.51.100.1"))
+
+	if got := requested(); len(got) != 2 {
+		t.Errorf("looked up %q, want each indicator once", got)
+	}
+}
+
+func TestEnrichmentBudget(t *testing.T) {
+	misp, requested := newMISP(t, "secret", 200*time.Millisecond)
+	configureMISP(t, misp, 20*time.Millisecond)
+
+	out, _ := Normalize(ipEvent("203.0.113.7"))
+	doc, _ := ParseDocument(out)
+	if doc.Exists("threat") || doc.GetString("enrichment.budget_exceeded") != "true" ||
+		doc.GetString("enrichment.status.misp") != EnrichTimedOut {
+		t.Fatalf("event over budget: %s", out)
+	}
+
+	// The lookup finishes in the background and serves the next event.
+	time.Sleep(300 * time.Millisecond)
+	out, _ = Normalize(ipEvent("203.0.113.7"))
+	doc, _ = ParseDocument(out)
+	if !doc.Exists("threat.enrichments") || doc.Exists("enrichment.budget_exceeded") {
+		t.Fatalf("event after the lookup finished: %s", out)
+	}
+	if got := requested(); len(got) != 1 {
+		t.Errorf("looked up %q, want one lookup", got)
+	}
+}
+
+func TestEnrichmentTimeout(t *testing.T) {
+	misp, _ := newMISP(t, "secret", 300*time.Millisecond)
+	configure(t, &config.Config{
+		MISPURL:           misp.URL,
+		MISPTimeout:       100 * time.Millisecond,
+		EnrichConcurrency: 1,
+	})
+
+	// One event holds the only slot until its request times out, the other
+	// times out waiting for it rather than queueing behind it.
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, ip := range []string{"203.0.113.7", "203.0.113.8"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, _ := Normalize(ipEvent(ip))
+			if doc, _ := ParseDocument(out); doc.GetString("enrichment.status.misp") != EnrichTimedOut {
+				t.Errorf("lookup of %s: %s", ip, out)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("lookups took %s, want them bounded by the timeout", elapsed)
+	}
+}
+
+func TestLookupCache(t *testing.T) {
+	c := newLookupCache(2, time.Hour, time.Millisecond)
+	c.add("a", lookupResult{Value: "A", Found: true})
+	c.add("b", lookupResult{Value: "B", Found: true})
+	c.get("a")
+	c.add("c", lookupResult{Value: "C", Found: true})
+	if _, ok := c.get("b"); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	if r, ok := c.get("a"); !ok || r.Value != "A" {
+		t.Errorf("a = %v, %t", r, ok)
+	}
+
+	c.add("miss", lookupResult{})
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.get("miss"); ok {
+		t.Error("negative result did not expire")
+	}
+}
+
+func TestEnrichmentBreaker(t *testing.T) {
+	var healthy atomic.Bool
+	var requests atomic.Int32
+	misp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"response":{"Attribute":[]}}`))
+	}))
+	t.Cleanup(misp.Close)
+	configure(t, &config.Config{
+		MISPURL:               misp.URL,
+		EnrichBreakerFailures: 2,
+		EnrichBreakerCooldown: 50 * time.Millisecond,
+	})
+
+	status := func(ip string) string {
+		out, _ := Normalize(ipEvent(ip))
+		doc, _ := ParseDocument(out)
+		return doc.GetString("enrichment.status.misp")
+	}
+	for i, want := range []string{EnrichFailed, EnrichFailed, EnrichSkipped, EnrichSkipped} {
+		if got := status(fmt.Sprintf("198.51.100.%d", i)); got != want {
+			t.Errorf("event %d: status %q, want %q", i, got, want)
+		}
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("%d requests to MISP, want 2 before the breaker opened", n)
+	}
+
+	// After the cooldown a probe goes through and closes the breaker.
+	time.Sleep(60 * time.Millisecond)
+	healthy.Store(true)
+	if got := status("198.51.100.10"); got != EnrichSucceeded {
+		t.Errorf("probe: status %q", got)
+	}
+	if got := status("198.51.100.11"); got != EnrichSucceeded {
+		t.Errorf("after probe: status %q", got)
+	}
+	stats := EnrichmentStats()
+	if len(stats) != 1 || stats[0].Breaker != BreakerClosed || stats[0].BreakerOpened != 1 || stats[0].Skipped != 2 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestMISPBatch(t *testing.T) {
+	event := `{"decoder":{"name":"fortigate"},"data":{"srcip":"198.51.100.1","dstip":"203.0.113.7"}}`
+	for _, tc := range []struct {
+		batchSize int
+		requests  []string
+	}{
+		{50, []string{"198.51.100.1,203.0.113.7"}},
+		{1, []string{"198.51.100.1", "203.0.113.7"}},
+	} {
+		misp, requested := newMISP(t, "secret", 0)
+		configure(t, &config.Config{MISPURL: misp.URL, MISPIOCFields: "source.ip,destination.ip", MISPBatchSize: tc.batchSize})
+
+		out, _ := Normalize(event)
+		doc, _ := ParseDocument(out)
+		if got := doc.GetString("threat.enrichments.0.matched.field"); got != "destination.ip" || doc.Exists("threat.enrichments.1") ||
+			doc.GetString("misp.category") != "Network activity" {
+			t.Errorf("batch size %d: enrichments of %s", tc.batchSize, out)
+		}
+		got := requested()
+		slices.Sort(got)
+		if !slices.Equal(got, tc.requests) {
+			t.Errorf("batch size %d: requests %q, want %q", tc.batchSize, got, tc.requests)
+		}
+	}
+}
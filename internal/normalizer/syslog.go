@@ -0,0 +1,497 @@
+package normalizer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/izzatbey/soc-norm-events/internal/config"
+)
+
+// maxSyslogMessage bounds a single syslog message, datagram or frame.
+const maxSyslogMessage = 64 * 1024
+
+// wazuhTimestamp is the timestamp layout of Wazuh alerts.
+const wazuhTimestamp = "2006-01-02T15:04:05.000-0700"
+
+// syslogMessage is a parsed RFC 3164 or RFC 5424 message. Fields the
+// message does not carry are empty; Priority is -1 without a PRI part.
+type syslogMessage struct {
+	Format         string
+	Priority       int
+	Timestamp      time.Time
+	TimestampRaw   string
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData string
+	Message        string
+	// Line is the message without its PRI part, Wazuh's full_log.
+	Line string
+}
+
+// parseSyslog parses one syslog message received at received. It never
+// fails: text that follows neither RFC is kept whole as the message.
+func parseSyslog(line []byte, received time.Time) syslogMessage {
+	msg := syslogMessage{Priority: -1}
+	s := strings.TrimRight(string(line), "\r\n\x00")
+
+	if strings.HasPrefix(s, "<") {
+		if end := strings.IndexByte(s, '>'); end > 1 && end <= 4 {
+			if pri, err := strconv.Atoi(s[1:end]); err == nil && pri <= 191 {
+				msg.Priority = pri
+				s = s[end+1:]
+			}
+		}
+	}
+	msg.Line = s
+
+	if strings.HasPrefix(s, "1 ") {
+		msg.Format = "rfc5424"
+		parse5424(&msg, s[2:])
+	} else {
+		msg.Format = "rfc3164"
+		parse3164(&msg, s, received)
+	}
+	return msg
+}
+
+// parse5424 parses what follows "<PRI>1 ":
+// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG].
+func parse5424(msg *syslogMessage, s string) {
+	var fields [5]string
+	for i := range fields {
+		fields[i], s, _ = strings.Cut(s, " ")
+		if fields[i] == "-" {
+			fields[i] = ""
+		}
+	}
+	msg.TimestampRaw = fields[0]
+	if t, err := time.Parse(time.RFC3339Nano, fields[0]); err == nil {
+		msg.Timestamp = t
+	}
+	msg.Hostname, msg.AppName, msg.ProcID, msg.MsgID = fields[1], fields[2], fields[3], fields[4]
+
+	sd, rest := splitStructuredData(s)
+	if sd != "-" {
+		msg.StructuredData = sd
+	}
+	msg.Message = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\ufeff")
+}
+
+// splitStructuredData splits the STRUCTURED-DATA part, "-" or a run of
+// [...] elements whose quoted values may contain escaped characters, from
+// the message that follows it.
+func splitStructuredData(s string) (sd, rest string) {
+	if !strings.HasPrefix(s, "[") {
+		sd, rest, _ = strings.Cut(s, " ")
+		return sd, rest
+	}
+	inElement, inQuote, escaped := false, false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inQuote && c == '\\':
+			escaped = true
+		case c == '"' && inElement:
+			inQuote = !inQuote
+		case inQuote:
+		case c == '[':
+			inElement = true
+		case c == ']':
+			inElement = false
+		case !inElement:
+			return s[:i], s[i:]
+		}
+	}
+	return s, ""
+}
+
+// parse3164 parses the BSD format: TIMESTAMP HOSTNAME TAG: MSG, where
+// TIMESTAMP is "Jan _2 15:04:05" without a year, or an RFC 3339 timestamp
+// as many devices send. Without a recognizable timestamp the whole text is
+// the message.
+func parse3164(msg *syslogMessage, s string, received time.Time) {
+	rest, ok := parse3164Timestamp(msg, s, received)
+	if !ok {
+		msg.Message = s
+		return
+	}
+
+	host, after, _ := strings.Cut(rest, " ")
+	if isTag(host) {
+		// No hostname, the tag follows the timestamp.
+		after = rest
+	} else {
+		msg.Hostname = host
+	}
+
+	tag, message, found := strings.Cut(after, ": ")
+	if !found || !isTag(tag+":") {
+		msg.Message = after
+		return
+	}
+	if name, pid, ok := strings.Cut(tag, "["); ok {
+		msg.AppName = name
+		msg.ProcID = strings.TrimSuffix(pid, "]")
+	} else {
+		msg.AppName = tag
+	}
+	msg.Message = message
+}
+
+func parse3164Timestamp(msg *syslogMessage, s string, received time.Time) (string, bool) {
+	if len(s) >= len(time.Stamp) {
+		if t, err := time.ParseInLocation(time.Stamp, s[:len(time.Stamp)], received.Location()); err == nil {
+			// The year is not sent; a date ahead of the receipt time is
+			// from last year.
+			t = t.AddDate(received.Year(), 0, 0)
+			if t.After(received.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			msg.Timestamp = t
+			msg.TimestampRaw = s[:len(time.Stamp)]
+			return strings.TrimPrefix(s[len(time.Stamp):], " "), true
+		}
+	}
+	first, rest, _ := strings.Cut(s, " ")
+	if t, err := time.Parse(time.RFC3339Nano, first); err == nil {
+		msg.Timestamp = t
+		msg.TimestampRaw = first
+		return rest, true
+	}
+	return s, false
+}
+
+// isTag reports whether s looks like "app:" or "app[pid]:".
+func isTag(s string) bool {
+	if !strings.HasSuffix(s, ":") || len(s) < 2 || len(s) > 64 {
+		return false
+	}
+	name, _, _ := strings.Cut(strings.TrimSuffix(s, ":"), "[")
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("-_./", r) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+var syslogSeq atomic.Uint64
+
+// envelope builds the Wazuh-like event the rules expect from msg, received
+// at received from peer over transport.
+func (msg syslogMessage) envelope(received time.Time, transport, peer string) []byte {
+	doc := &Document{root: newObject()}
+	ts := msg.Timestamp
+	if ts.IsZero() {
+		ts = received
+	}
+	hostname := msg.Hostname
+	if hostname == "" {
+		hostname = peer
+	}
+
+	doc.Set("timestamp", ts.Format(wazuhTimestamp))
+	doc.Set("id", fmt.Sprintf("%d.%d", received.Unix(), syslogSeq.Add(1)))
+	doc.Set("agent.name", hostname)
+	doc.Set("location", peer)
+	doc.Set("full_log", msg.Line)
+	doc.Set("predecoder.hostname", hostname)
+	if msg.AppName != "" {
+		doc.Set("predecoder.program_name", msg.AppName)
+	}
+	if msg.TimestampRaw != "" {
+		doc.Set("predecoder.timestamp", msg.TimestampRaw)
+	}
+
+	doc.Set("syslog.format", msg.Format)
+	doc.Set("syslog.transport", transport)
+	if msg.Priority >= 0 {
+		doc.Set("syslog.priority", msg.Priority)
+		doc.Set("syslog.facility", msg.Priority/8)
+		doc.Set("syslog.severity", msg.Priority%8)
+	}
+	for _, f := range []struct{ field, value string }{
+		{"syslog.procid", msg.ProcID},
+		{"syslog.msgid", msg.MsgID},
+		{"syslog.structured_data", msg.StructuredData},
+	} {
+		if f.value != "" {
+			doc.Set(f.field, f.value)
+		}
+	}
+	doc.Set("syslog.received", received.Format(wazuhTimestamp))
+	doc.Set("message", msg.Message)
+	return []byte(doc.String())
+}
+
+// syslogSource receives syslog over UDP, TCP and TLS and turns every
+// message into a Wazuh-like event. TCP and TLS streams may use octet
+// counting (RFC 6587 "123 <34>1 ...") or newline-delimited framing.
+type syslogSource struct {
+	cfg    *config.Config
+	events chan Event
+
+	mu        sync.Mutex
+	closed    bool
+	listeners []io.Closer
+	conns     map[net.Conn]struct{}
+}
+
+func newSyslogSource(cfg *config.Config) (*syslogSource, error) {
+	if cfg.SyslogUDPAddr == "" && cfg.SyslogTCPAddr == "" && cfg.SyslogTLSAddr == "" {
+		return nil, errors.New("syslog source needs SYSLOG_UDP_ADDR, SYSLOG_TCP_ADDR or SYSLOG_TLS_ADDR")
+	}
+	if cfg.SyslogTLSAddr != "" && (cfg.SyslogTLSCert == "" || cfg.SyslogTLSKey == "") {
+		return nil, errors.New("syslog over TLS needs SYSLOG_TLS_CERT and SYSLOG_TLS_KEY")
+	}
+	return &syslogSource{cfg: cfg, events: make(chan Event, 1000)}, nil
+}
+
+func (s *syslogSource) Events(ctx context.Context, handle func(Event)) error {
+	if err := s.listen(ctx); err != nil {
+		s.Close()
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return nil
+		case ev := <-s.events:
+			handle(ev)
+		}
+	}
+}
+
+// listen opens every configured listener and serves it in the background.
+func (s *syslogSource) listen(ctx context.Context) error {
+	if addr := s.cfg.SyslogUDPAddr; addr != "" {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return fmt.Errorf("syslog udp: %w", err)
+		}
+		s.track(conn)
+		log.Printf("📡 Receiving syslog on udp %s", conn.LocalAddr())
+		go s.serveUDP(ctx, conn)
+	}
+	if addr := s.cfg.SyslogTCPAddr; addr != "" {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("syslog tcp: %w", err)
+		}
+		s.track(ln)
+		log.Printf("📡 Receiving syslog on tcp %s", ln.Addr())
+		go s.serveStream(ctx, ln, "tcp")
+	}
+	if addr := s.cfg.SyslogTLSAddr; addr != "" {
+		cert, err := tls.LoadX509KeyPair(s.cfg.SyslogTLSCert, s.cfg.SyslogTLSKey)
+		if err != nil {
+			return fmt.Errorf("syslog tls: %w", err)
+		}
+		ln, err := tls.Listen("tcp", addr, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+		if err != nil {
+			return fmt.Errorf("syslog tls: %w", err)
+		}
+		s.track(ln)
+		log.Printf("📡 Receiving syslog on tls %s", ln.Addr())
+		go s.serveStream(ctx, ln, "tls")
+	}
+	return nil
+}
+
+func (s *syslogSource) track(c io.Closer) {
+	s.mu.Lock()
+	s.listeners = append(s.listeners, c)
+	s.mu.Unlock()
+}
+
+// trackConn records an accepted connection for Close, unless the source
+// is already closed.
+func (s *syslogSource) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+// untrackConn closes a connection its reader is done with.
+func (s *syslogSource) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	conn.Close()
+}
+
+// emit queues one received message as an event.
+func (s *syslogSource) emit(ctx context.Context, line []byte, transport string, peer net.Addr) {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return
+	}
+	received := time.Now()
+	host := peer.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ev := Event{Value: parseSyslog(line, received).envelope(received, transport, host)}
+	select {
+	case s.events <- ev:
+	case <-ctx.Done():
+	}
+}
+
+func (s *syslogSource) serveUDP(ctx context.Context, conn net.PacketConn) {
+	buf := make([]byte, maxSyslogMessage)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				log.Printf("⚠️ Syslog udp: %v", err)
+			}
+			return
+		}
+		s.emit(ctx, bytes.Clone(buf[:n]), "udp", peer)
+	}
+}
+
+func (s *syslogSource) serveStream(ctx context.Context, ln net.Listener, transport string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				log.Printf("⚠️ Syslog %s: %v", transport, err)
+			}
+			return
+		}
+		if !s.trackConn(conn) {
+			conn.Close()
+			return
+		}
+		go func() {
+			defer s.untrackConn(conn)
+			err := readSyslogFrames(conn, func(frame []byte) {
+				s.emit(ctx, frame, transport, conn.RemoteAddr())
+			})
+			if err != nil && ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				log.Printf("⚠️ Syslog %s from %s: %v", transport, conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// readSyslogFrames calls handle for every message of a syslog stream. A
+// frame starting with an octet count, up to five digits and a space, is
+// octet-counted; any other is terminated by a newline (or NUL), so
+// newline-delimited messages may start with a digit too.
+func readSyslogFrames(r io.Reader, handle func(frame []byte)) error {
+	br := bufio.NewReaderSize(r, maxSyslogMessage)
+	for {
+		if _, err := br.Peek(1); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if n, ok := peekOctetCount(br); ok {
+			if n <= 0 || n > maxSyslogMessage {
+				return fmt.Errorf("invalid octet count %d", n)
+			}
+			frame := make([]byte, n)
+			if _, err := io.ReadFull(br, frame); err != nil {
+				return err
+			}
+			handle(frame)
+			continue
+		}
+
+		frame, err := readDelimited(br)
+		if len(frame) > 0 {
+			handle(frame)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// maxOctetCountDigits is the length of the longest octet count accepted,
+// enough for maxSyslogMessage.
+const maxOctetCountDigits = 5
+
+// peekOctetCount reports the octet count br starts with and consumes it,
+// or consumes nothing if br does not start with digits and a space.
+func peekOctetCount(br *bufio.Reader) (int, bool) {
+	for i := 1; i <= maxOctetCountDigits+1; i++ {
+		b, err := br.Peek(i)
+		if err != nil {
+			return 0, false
+		}
+		c := b[i-1]
+		if c == ' ' && i > 1 {
+			n, _ := strconv.Atoi(string(b[:i-1]))
+			br.Discard(i)
+			return n, true
+		}
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+// readDelimited reads up to and excluding the next newline or NUL.
+func readDelimited(br *bufio.Reader) ([]byte, error) {
+	var frame []byte
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return frame, err
+		}
+		if c == '\n' || c == 0 {
+			return frame, nil
+		}
+		if len(frame) >= maxSyslogMessage {
+			return nil, fmt.Errorf("message longer than %d bytes", maxSyslogMessage)
+		}
+		frame = append(frame, c)
+	}
+}
+
+func (s *syslogSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for _, c := range s.listeners {
+		c.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.listeners, s.conns = nil, nil
+	return nil
+}
@@ -0,0 +1,191 @@
+package normalizer
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/izzatbey/soc-norm-events/internal/config"
+)
+
+func TestParseSyslog(t *testing.T) {
+	received := time.Date(2025, time.January, 2, 10, 0, 0, 0, time.UTC)
+	for name, tc := range map[string]struct {
+		line string
+		want syslogMessage
+	}{
+		"rfc3164": {
+			line: "<134>Jan  2 09:59:58 esxi-01 Hostd[2099]: Task completed\n",
+			want: syslogMessage{
+				Format: "rfc3164", Priority: 134,
+				Timestamp: time.Date(2025, time.January, 2, 9, 59, 58, 0, time.UTC), TimestampRaw: "Jan  2 09:59:58",
+				Hostname: "esxi-01", AppName: "Hostd", ProcID: "2099", Message: "Task completed",
+				Line: "Jan  2 09:59:58 esxi-01 Hostd[2099]: Task completed",
+			},
+		},
+		"rfc3164 last year": {
+			line: "<13>Dec 31 23:59:59 h3c-core %%10SHELL/5/SHELL_LOGIN: admin logged in",
+			want: syslogMessage{
+				Format: "rfc3164", Priority: 13,
+				Timestamp: time.Date(2024, time.December, 31, 23, 59, 59, 0, time.UTC), TimestampRaw: "Dec 31 23:59:59",
+				Hostname: "h3c-core", Message: "%%10SHELL/5/SHELL_LOGIN: admin logged in",
+				Line: "Dec 31 23:59:59 h3c-core %%10SHELL/5/SHELL_LOGIN: admin logged in",
+			},
+		},
+		"rfc3164 without hostname": {
+			line: "<30>Jan  2 09:00:00 sshd[12]: Accepted publickey",
+			want: syslogMessage{
+				Format: "rfc3164", Priority: 30,
+				Timestamp: time.Date(2025, time.January, 2, 9, 0, 0, 0, time.UTC), TimestampRaw: "Jan  2 09:00:00",
+				AppName: "sshd", ProcID: "12", Message: "Accepted publickey",
+				Line: "Jan  2 09:00:00 sshd[12]: Accepted publickey",
+			},
+		},
+		"rfc3339 timestamp": {
+			line: "<189>2025-01-02T09:30:00+07:00 FGT60F date=2025-01-02 devname=FGT60F",
+			want: syslogMessage{
+				Format: "rfc3164", Priority: 189,
+				Timestamp: time.Date(2025, time.January, 2, 9, 30, 0, 0, time.FixedZone("", 7*3600)), TimestampRaw: "2025-01-02T09:30:00+07:00",
+				Hostname: "FGT60F", Message: "date=2025-01-02 devname=FGT60F",
+				Line: "2025-01-02T09:30:00+07:00 FGT60F date=2025-01-02 devname=FGT60F",
+			},
+		},
+		"no header": {
+			line: "just a message",
+			want: syslogMessage{Format: "rfc3164", Priority: -1, Message: "just a message", Line: "just a message"},
+		},
+		"rfc5424": {
+			line: `<165>1 2025-01-02T09:00:00.003Z ntnx-cvm app 811 ID47 [exampleSDID@32473 iut="3" eventSource="App] \"x\""] ` + "\ufeff" + "An application event",
+			want: syslogMessage{
+				Format: "rfc5424", Priority: 165,
+				Timestamp: time.Date(2025, time.January, 2, 9, 0, 0, 3e6, time.UTC), TimestampRaw: "2025-01-02T09:00:00.003Z",
+				Hostname: "ntnx-cvm", AppName: "app", ProcID: "811", MsgID: "ID47",
+				StructuredData: `[exampleSDID@32473 iut="3" eventSource="App] \"x\""]`,
+				Message:        "An application event",
+				Line:           `1 2025-01-02T09:00:00.003Z ntnx-cvm app 811 ID47 [exampleSDID@32473 iut="3" eventSource="App] \"x\""] ` + "\ufeff" + "An application event",
+			},
+		},
+		"rfc5424 nil values": {
+			line: "<14>1 - - - - - -",
+			want: syslogMessage{Format: "rfc5424", Priority: 14, TimestampRaw: "", Line: "1 - - - - - -"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got := parseSyslog([]byte(tc.line), received)
+			if !got.Timestamp.Equal(tc.want.Timestamp) {
+				t.Errorf("timestamp %v, want %v", got.Timestamp, tc.want.Timestamp)
+			}
+			got.Timestamp, tc.want.Timestamp = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got  %+v\nwant %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadSyslogFrames(t *testing.T) {
+	stream := "<13>Jan  2 09:00:00 a x: one\n" +
+		"28 <13>Jan  2 09:00:00 b x: two" +
+		"<13>Jan  2 09:00:00 c x: three\x00" +
+		"35 <13>Jan  2 09:00:00 d x: multi\nline" +
+		"2025-01-02T09:00:00Z f x: no PRI\n" +
+		"123456 digits without a count\n" +
+		"<13>Jan  2 09:00:00 e x: unterminated"
+	var frames []string
+	if err := readSyslogFrames(strings.NewReader(stream), func(frame []byte) {
+		frames = append(frames, string(frame))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"<13>Jan  2 09:00:00 a x: one",
+		"<13>Jan  2 09:00:00 b x: two",
+		"<13>Jan  2 09:00:00 c x: three",
+		"<13>Jan  2 09:00:00 d x: multi\nline",
+		"2025-01-02T09:00:00Z f x: no PRI",
+		"123456 digits without a count",
+		"<13>Jan  2 09:00:00 e x: unterminated",
+	}
+	if !reflect.DeepEqual(frames, want) {
+		t.Fatalf("frames %q, want %q", frames, want)
+	}
+
+	if err := readSyslogFrames(strings.NewReader("99999 <13>x"), func([]byte) {}); err == nil {
+		t.Fatal("oversized octet count was accepted")
+	}
+}
+
+func TestSyslogSourceConnections(t *testing.T) {
+	s, err := newSyslogSource(&config.Config{SyslogTCPAddr: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.listen(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	addr := s.listeners[0].(net.Listener).Addr().String()
+
+	conns := func() int {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.conns)
+	}
+	// A connection is forgotten once its sender hangs up.
+	for range 3 {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("<13>Jan  2 09:00:00 a x: one\n"))
+		<-s.events
+		conn.Close()
+	}
+	deadline := time.Now().Add(time.Second)
+	for conns() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d closed connections still tracked", conns())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSyslogEnvelope(t *testing.T) {
+	received := time.Date(2025, time.January, 2, 10, 0, 0, 0, time.UTC)
+	raw := parseSyslog([]byte("<134>Jan  2 09:59:58 esxi-01 Hostd[2099]: Task completed"), received).
+		envelope(received, "udp", "10.0.0.5")
+	doc, err := ParseDocument(string(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for field, want := range map[string]string{
+		"timestamp":               "2025-01-02T09:59:58.000+0000",
+		"agent.name":              "esxi-01",
+		"predecoder.hostname":     "esxi-01",
+		"predecoder.program_name": "Hostd",
+		"predecoder.timestamp":    "Jan  2 09:59:58",
+		"full_log":                "Jan  2 09:59:58 esxi-01 Hostd[2099]: Task completed",
+		"location":                "10.0.0.5",
+		"syslog.facility":         "16",
+		"syslog.severity":         "6",
+		"syslog.transport":        "udp",
+		"message":                 "Task completed",
+	} {
+		if got := doc.GetString(field); got != want {
+			t.Errorf("%s = %q, want %q", field, got, want)
+		}
+	}
+
+	out, err := ParseDocument(ApplyRules(string(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Exists("normalizer.failed_rules") {
+		t.Fatalf("rules failed on the envelope: %s", out)
+	}
+}
@@ -0,0 +1,187 @@
+package normalizer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Field change kinds reported by Explain.
+const (
+	FieldAdded       = "added"
+	FieldRenamed     = "renamed"
+	FieldOverwritten = "overwritten"
+	FieldDeleted     = "deleted"
+)
+
+// Trace is the step-by-step account of how one event was normalized.
+type Trace struct {
+	Category string          `json:"category"`
+	Ruleset  string          `json:"ruleset"`
+	Rules    []RuleTrace     `json:"rules"`
+	Error    string          `json:"error,omitempty"`
+	Output   json.RawMessage `json:"output"`
+}
+
+// RuleTrace lists the fields one rule changed. Phase is "before", the
+// category of the pipeline the rule belongs to, "after", or "enrich" for
+// stage-6 enrichment.
+type RuleTrace struct {
+	Rule    string        `json:"rule"`
+	Phase   string        `json:"phase"`
+	Error   string        `json:"error,omitempty"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// FieldChange is one leaf field a rule added, renamed, overwrote or deleted.
+// From is set for renames only; Old is unset for additions and New for
+// deletions.
+type FieldChange struct {
+	Kind  string          `json:"kind"`
+	Field string          `json:"field"`
+	From  string          `json:"from,omitempty"`
+	Old   json.RawMessage `json:"old,omitempty"`
+	New   json.RawMessage `json:"new,omitempty"`
+}
+
+// Explain normalizes raw with the active ruleset, as Normalize does, and
+// records what every rule changed. Unless category is empty it is used
+// instead of the detected one.
+func Explain(raw, category string) (*Trace, error) {
+	rs := ActiveRuleset()
+	if category != "" && !slices.Contains(rs.Categories(), category) {
+		return nil, fmt.Errorf("unknown source category %q (ruleset has %s)", category, strings.Join(rs.Categories(), ", "))
+	}
+	doc, err := ParseDocument(raw)
+	if err != nil || !doc.IsObject() {
+		return nil, errors.New("event is not a JSON object")
+	}
+	t := &tracer{trace: &Trace{Ruleset: rs.String(), Rules: []RuleTrace{}}}
+	if err := rs.run(doc, category, t); err != nil {
+		t.trace.Error = err.Error()
+	}
+	t.trace.Output = json.RawMessage(doc.String())
+	return t.trace, nil
+}
+
+// DiffFields compares two events, typically the same event normalized by
+// two rulesets, and returns the leaf fields that differ between them as
+// Explain reports them.
+func DiffFields(before, after string) ([]FieldChange, error) {
+	b, err := ParseDocument(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := ParseDocument(after)
+	if err != nil {
+		return nil, err
+	}
+	return diffLeaves(leaves(b), leaves(a)), nil
+}
+
+// tracer collects a Trace while a ruleset runs. All of its methods do
+// nothing on a nil *tracer, so the normal pipeline pays nothing for it.
+type tracer struct {
+	trace    *Trace
+	current  string
+	snapshot []leaf
+}
+
+// leaf is a scalar, array or empty object of a Document with its path.
+type leaf struct {
+	path  string
+	value string
+}
+
+func (t *tracer) phase(name string) {
+	if t != nil {
+		t.current = name
+	}
+}
+
+func (t *tracer) category(name string) {
+	if t != nil {
+		t.trace.Category = name
+		t.current = name
+	}
+}
+
+func (t *tracer) before(doc *Document) {
+	if t != nil {
+		t.snapshot = leaves(doc)
+	}
+}
+
+func (t *tracer) after(doc *Document, name string, ruleErr *RuleError) {
+	if t == nil {
+		return
+	}
+	rt := RuleTrace{Rule: name, Phase: t.current, Changes: diffLeaves(t.snapshot, leaves(doc))}
+	if ruleErr != nil {
+		rt.Error = ruleErr.Error()
+	}
+	t.trace.Rules = append(t.trace.Rules, rt)
+}
+
+// leaves flattens doc into its leaf fields in document order.
+func leaves(doc *Document) []leaf {
+	var out []leaf
+	var walk func(prefix string, v any)
+	walk = func(prefix string, v any) {
+		if o, ok := v.(*object); ok && len(o.keys) > 0 {
+			for _, k := range o.keys {
+				p := k
+				if prefix != "" {
+					p = prefix + "." + k
+				}
+				walk(p, o.values[k])
+			}
+			return
+		}
+		out = append(out, leaf{prefix, string(appendJSON(nil, v))})
+	}
+	walk("", doc.root)
+	return out
+}
+
+// diffLeaves compares two flattened documents. A field that disappeared
+// while another appeared with the same value is reported as a rename.
+func diffLeaves(before, after []leaf) []FieldChange {
+	old := make(map[string]string, len(before))
+	for _, l := range before {
+		old[l.path] = l.value
+	}
+	now := make(map[string]string, len(after))
+	for _, l := range after {
+		now[l.path] = l.value
+	}
+
+	var deleted []leaf
+	for _, l := range before {
+		if _, ok := now[l.path]; !ok {
+			deleted = append(deleted, l)
+		}
+	}
+
+	changes := []FieldChange{}
+	for _, l := range after {
+		prev, existed := old[l.path]
+		switch {
+		case !existed:
+			if i := slices.IndexFunc(deleted, func(d leaf) bool { return d.value == l.value }); i >= 0 {
+				changes = append(changes, FieldChange{Kind: FieldRenamed, Field: l.path, From: deleted[i].path, New: json.RawMessage(l.value)})
+				deleted = slices.Delete(deleted, i, i+1)
+				continue
+			}
+			changes = append(changes, FieldChange{Kind: FieldAdded, Field: l.path, New: json.RawMessage(l.value)})
+		case prev != l.value:
+			changes = append(changes, FieldChange{Kind: FieldOverwritten, Field: l.path, Old: json.RawMessage(prev), New: json.RawMessage(l.value)})
+		}
+	}
+	for _, d := range deleted {
+		changes = append(changes, FieldChange{Kind: FieldDeleted, Field: d.path, Old: json.RawMessage(d.value)})
+	}
+	return changes
+}
@@ -0,0 +1,289 @@
+package normalizer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/izzatbey/soc-norm-events/internal/config"
+)
+
+// txnBatch is the set of input messages covered by the open transaction.
+type txnBatch struct {
+	open    bool
+	started time.Time
+	size    int
+	first   map[partitionKey]kafka.Offset
+	next    map[partitionKey]kafka.Offset
+}
+
+func newTxnBatch() *txnBatch {
+	return &txnBatch{
+		first: map[partitionKey]kafka.Offset{},
+		next:  map[partitionKey]kafka.Offset{},
+	}
+}
+
+func (b *txnBatch) add(tp kafka.TopicPartition) {
+	key := partitionKey{topic: *tp.Topic, partition: tp.Partition}
+	if _, ok := b.first[key]; !ok {
+		b.first[key] = tp.Offset
+	}
+	b.next[key] = tp.Offset + 1
+	b.size++
+}
+
+func (b *txnBatch) reset() {
+	b.open = false
+	b.size = 0
+	b.first = map[partitionKey]kafka.Offset{}
+	b.next = map[partitionKey]kafka.Offset{}
+}
+
+// offsets returns the consumer positions to commit with the transaction.
+func (b *txnBatch) offsets() []kafka.TopicPartition {
+	offsets := make([]kafka.TopicPartition, 0, len(b.next))
+	for key, offset := range b.next {
+		topic := key.topic
+		offsets = append(offsets, kafka.TopicPartition{Topic: &topic, Partition: key.partition, Offset: offset})
+	}
+	return offsets
+}
+
+// rewind seeks every partition in the batch back to its first message so
+// the aborted messages are consumed and normalized again. Partitions listed
+// in skip are no longer assigned and are left alone.
+func (b *txnBatch) rewind(consumer *kafka.Consumer, skip []kafka.TopicPartition) {
+	revoked := map[partitionKey]bool{}
+	for _, tp := range skip {
+		revoked[partitionKey{topic: *tp.Topic, partition: tp.Partition}] = true
+	}
+	for key, offset := range b.first {
+		if revoked[key] {
+			continue
+		}
+		topic := key.topic
+		tp := kafka.TopicPartition{Topic: &topic, Partition: key.partition, Offset: offset}
+		if err := consumer.Seek(tp, 0); err != nil {
+			log.Printf("⚠️ Rewind of %s[%d] to %v failed: %v", topic, key.partition, offset, err)
+		}
+	}
+}
+
+// runTransactional wraps each batch of normalized events, together with the
+// consumer offsets of their inputs, in a Kafka transaction. A batch is either
+// visible to read_committed consumers with its offsets committed, or aborted
+// and consumed again. The worker pool is drained before every commit or abort
+// so a transaction never closes with its messages still being produced.
+func runTransactional(ctx context.Context, cfg *config.Config, consumer *kafka.Consumer, producer *kafka.Producer) error {
+	initCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	err := producer.InitTransactions(initCtx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to init transactions: %w", err)
+	}
+
+	batch := newTxnBatch()
+	var (
+		msgCount   uint64
+		produceMu  sync.Mutex
+		produceErr error
+	)
+
+	process := func(msg *kafka.Message) {
+		out := &kafka.Message{
+			TopicPartition: kafka.TopicPartition{
+				Topic:     &cfg.OutputTopic,
+				Partition: kafka.PartitionAny,
+			},
+			Key: msg.Key,
+		}
+		normalized, err := Normalize(string(msg.Value))
+		failed := err != nil
+		if failed {
+			log.Printf("⚠️ Normalization failed for %v: %v", msg.TopicPartition, err)
+			topic := deadLetterTopic(cfg, stageOf(err))
+			if topic == "" {
+				log.Printf("⚠️ Dropping event %v: no dead-letter topic configured", msg.TopicPartition)
+				return
+			}
+			out = deadLetter(topic, msg.Value, msg.Key, msg.TopicPartition, stageOf(err), err)
+		} else {
+			out.Value = []byte(normalized)
+		}
+
+		err = produce(producer, out)
+		if err != nil && !failed && cfg.DeadLetterTopic != "" {
+			// Dead-letter the event, as runAtLeastOnce does, rather than
+			// abort: after the rewind it would only fail again.
+			log.Printf("Message Produce Error: %v", err)
+			err = produce(producer, deadLetter(cfg.DeadLetterTopic, msg.Value, msg.Key, msg.TopicPartition, StageProduce, err))
+		}
+		if kerr, ok := err.(kafka.Error); ok && kerr.Code() == kafka.ErrMsgSizeTooLarge {
+			// Neither the event nor its dead letter fit in a message, and
+			// aborting would fail them again forever.
+			log.Printf("❌ Skipping event %v: %v", msg.TopicPartition, err)
+			return
+		}
+		if err != nil {
+			produceMu.Lock()
+			if produceErr == nil {
+				produceErr = err
+			}
+			produceMu.Unlock()
+			return
+		}
+		atomic.AddUint64(&msgCount, 1)
+	}
+	pool := newWorkerPool(cfg.Workers, cfg.WorkerQueueSize, cfg.Ordering, process)
+	defer pool.close()
+
+	abort := func(reason string, skip []kafka.TopicPartition) error {
+		pool.drain()
+		produceMu.Lock()
+		produceErr = nil
+		produceMu.Unlock()
+
+		log.Printf("⚠️ Aborting transaction of %d messages: %s", batch.size, reason)
+		abortCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := producer.AbortTransaction(abortCtx); err != nil {
+			return fmt.Errorf("abort transaction: %w", err)
+		}
+		batch.rewind(consumer, skip)
+		batch.reset()
+		return nil
+	}
+
+	var fatal error
+	rebalance := func(c *kafka.Consumer, ev kafka.Event) error {
+		if e, ok := ev.(kafka.RevokedPartitions); ok && batch.open {
+			// The new owner must not see output for offsets it will re-read.
+			if err := abort("partitions revoked", e.Partitions); err != nil {
+				fatal = err
+			}
+		}
+		return nil
+	}
+	if err := consumer.SubscribeTopics([]string{cfg.InputTopic}, rebalance); err != nil {
+		return fmt.Errorf("failed to subscribe: %w", err)
+	}
+
+	log.Printf("Normalizer starting in transactional mode (id=%s)...", cfg.TransactionalID)
+
+	go reportMetrics(ctx, &msgCount)
+
+	// Delivery failures surface again from CommitTransaction; log them here
+	// and keep the events channel drained.
+	go func() {
+		for e := range producer.Events() {
+			if ev, ok := e.(*kafka.Message); ok && ev.TopicPartition.Error != nil {
+				log.Printf("❌ Delivery failed: %v", ev.TopicPartition.Error)
+			}
+		}
+	}()
+
+	commit := func(ctx context.Context) error {
+		if !batch.open {
+			return nil
+		}
+		pool.drain()
+		produceMu.Lock()
+		err := produceErr
+		produceMu.Unlock()
+		if err != nil {
+			return abort(fmt.Sprintf("produce: %v", err), nil)
+		}
+
+		err = commitTransaction(ctx, consumer, producer, batch.offsets())
+		if err == nil {
+			log.Printf("✅ Committed transaction of %d messages", batch.size)
+			batch.reset()
+			return nil
+		}
+		if kerr, ok := err.(kafka.Error); ok && kerr.TxnRequiresAbort() {
+			return abort(err.Error(), nil)
+		}
+		// Abort a transaction that can no longer commit so its output is
+		// discarded now rather than when the transaction times out.
+		if aerr := abort(err.Error(), nil); aerr != nil {
+			log.Printf("❌ %v", aerr)
+		}
+		return err
+	}
+
+	for ctx.Err() == nil && fatal == nil {
+		if batch.open && (batch.size >= cfg.TransactionBatch || time.Since(batch.started) >= cfg.TransactionInterval) {
+			if err := commit(ctx); err != nil {
+				return err
+			}
+		}
+
+		msg, err := consumer.ReadMessage(100 * time.Millisecond)
+		if err != nil {
+			if kerr, ok := err.(kafka.Error); ok && kerr.IsTimeout() {
+				continue
+			}
+			log.Printf("Consumer Error: %v", err)
+			continue
+		}
+
+		if !batch.open {
+			if err := producer.BeginTransaction(); err != nil {
+				return fmt.Errorf("begin transaction: %w", err)
+			}
+			batch.open = true
+			batch.started = time.Now()
+		}
+		batch.add(msg.TopicPartition)
+
+		pool.dispatch(msg)
+	}
+	if fatal != nil {
+		return fatal
+	}
+
+	log.Printf("Shutdown requested, committing open transaction (timeout %s)", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	return commit(shutdownCtx)
+}
+
+// Retriable commit errors are retried after a delay that starts at
+// commitRetryMin and doubles up to commitRetryMax.
+const (
+	commitRetryMin = 100 * time.Millisecond
+	commitRetryMax = 5 * time.Second
+)
+
+// commitTransaction attaches the consumer offsets to the open transaction and
+// commits it, retrying with a backoff while the broker reports retriable
+// errors and ctx is not done.
+func commitTransaction(ctx context.Context, consumer *kafka.Consumer, producer *kafka.Producer, offsets []kafka.TopicPartition) error {
+	meta, err := consumer.GetConsumerGroupMetadata()
+	if err != nil {
+		return fmt.Errorf("consumer group metadata: %w", err)
+	}
+	if err := producer.SendOffsetsToTransaction(ctx, offsets, meta); err != nil {
+		return err
+	}
+	for delay := commitRetryMin; ; delay = min(2*delay, commitRetryMax) {
+		err := producer.CommitTransaction(ctx)
+		if err == nil {
+			return nil
+		}
+		if kerr, ok := err.(kafka.Error); !ok || !kerr.IsRetriable() {
+			return err
+		}
+		log.Printf("⚠️ Commit transaction retry in %s: %v", delay, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w (gave up: %w)", err, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
@@ -2,15 +2,12 @@ package normalizer
 
 import (
 	"strings"
-
-	"github.com/tidwall/gjson"
-	"github.com/tidwall/sjson"
 )
 
-func extractMitreInfo(raw string, ruleName string) string {
+func extractMitreInfo(doc *Document, ruleName string) {
 
 	if ruleName == "" || strings.EqualFold(ruleName, "-") {
-		return raw
+		return
 	}
 
 	var techniqueID, techniqueName string
@@ -25,27 +22,16 @@ func extractMitreInfo(raw string, ruleName string) string {
 	}
 
 	if techniqueID != "" {
-		raw, _ = sjson.Set(raw, "rule.mitre.id", techniqueID)
+		doc.Set("rule.mitre.id", techniqueID)
 	}
 	if techniqueName != "" {
-		raw, _ = sjson.Set(raw, "rule.mitre.technique", techniqueName)
+		doc.Set("rule.mitre.technique", techniqueName)
 	}
 
-	raw, _ = sjson.Delete(raw, "data.eventdata.ruleName")
-	return raw
-}
-
-func renameFields(raw string, mapping map[string]string) string {
-	for oldField, newField := range mapping {
-		if val := gjson.Get(raw, oldField); val.Exists() {
-			raw, _ = sjson.Set(raw, newField, val.Value())
-			raw, _ = sjson.Delete(raw, oldField)
-		}
-	}
-	return raw
+	doc.Delete("data.eventdata.ruleName")
 }
 
-func cleanFields(raw string) string {
+func cleanFields(doc *Document) {
 	dropList := []string{
 		"data.devname",
 		"data.eventdata.company",
@@ -110,38 +96,18 @@ func cleanFields(raw string) string {
 	nullValues := []string{"", "-", "null", "N/A"}
 
 	for _, key := range dropList {
-		if gjson.Get(raw, key).Exists() {
-			raw, _ = sjson.Delete(raw, key)
-		}
+		doc.Delete(key)
 	}
 
 	for _, section := range sections {
-		obj := gjson.Get(raw, section)
-		if obj.Exists() && obj.IsObject() {
-			for k, v := range obj.Map() {
-				strVal := strings.TrimSpace(v.String())
-				for _, nv := range nullValues {
-					if strings.EqualFold(strVal, nv) {
-						raw, _ = sjson.Delete(raw, section+"."+k)
-						break
-					}
+		for _, k := range doc.Keys(section) {
+			strVal := strings.TrimSpace(doc.GetString(section + "." + k))
+			for _, nv := range nullValues {
+				if strings.EqualFold(strVal, nv) {
+					doc.Delete(section + "." + k)
+					break
 				}
 			}
 		}
 	}
-
-	return raw
-}
-
-func setNetworkDirection(raw, initiated string) string {
-	initiated = strings.ToLower(strings.TrimSpace(initiated))
-
-	switch {
-	case strings.Contains(initiated, "true"):
-		raw, _ = sjson.Set(raw, "network.direction", "egress")
-	case strings.Contains(initiated, "false"):
-		raw, _ = sjson.Set(raw, "network.direction", "ingress")
-	}
-
-	return raw
 }